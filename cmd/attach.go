@@ -0,0 +1,56 @@
+package cmd
+
+import (
+	"errors"
+	"github.com/dewey/tbm/config"
+	"github.com/dewey/tbm/proc"
+	"github.com/spf13/cobra"
+	"io"
+)
+
+// attachCmd represents the attach command
+var attachCmd = &cobra.Command{
+	Use:   "attach [service]",
+	Short: "Follow the output of a running tbm",
+	Long: `Follow the output of all services, or a single service, of a tbm running in another terminal. The recent lines
+tbm keeps in memory are shown first, then new lines as they are written. Stop following with Ctrl+C, the services
+keep running. Services with a max lifetime show the time left until they are stopped first.`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		environment, err := cmd.Flags().GetString("env")
+		if err != nil {
+			return err
+		}
+		var service string
+		if len(args) > 0 {
+			service = args[0]
+		}
+		stateDir, err := config.StateDir()
+		if err != nil {
+			return err
+		}
+		client := proc.NewControlClient(proc.SocketPath(stateDir))
+		// If tbm isn't running, following the logs fails below
+		if services, err := client.Services(); err == nil {
+			for _, status := range services {
+				if status.ExpiresAt == nil || (service != "" && status.Service != service) || (environment != "" && status.Environment != environment) {
+					continue
+				}
+				cmd.Printf("%s (%s) is stopped in %s, it reaches its max lifetime\n", status.Service, status.Environment, formatLifetime(status))
+			}
+		}
+		err = client.Logs(service, environment, true, func(line string) error {
+			_, err := io.WriteString(cmd.OutOrStdout(), line)
+			return err
+		})
+		if errors.Is(err, proc.ErrNotRunning) {
+			return errors.New("tbm isn't running, start it with `tbm start`")
+		}
+		return err
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(attachCmd)
+	attachCmd.Flags().String("env", "", "Only follow services of this environment")
+}
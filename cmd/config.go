@@ -0,0 +1,450 @@
+package cmd
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dewey/tbm/config"
+	"github.com/dewey/tbm/remote"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Manage the configuration file",
+}
+
+// configPullCmd represents the config pull command
+var configPullCmd = &cobra.Command{
+	Use:   "pull",
+	Short: "Update the configuration file from its remote source",
+	Long: `Fetch the remote configuration file again and merge it into the local configuration file. Settings you didn't
+change are updated, your changes like enabled services or added services are kept. If you and the remote changed the
+same setting, your value is kept and the conflict is shown. A backup of the previous file is kept with the suffix .bak.
+
+Use --check to only show the changes and fail if the configuration file isn't up to date, for example in CI.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		configFilePath, err := configPath(cmd)
+		if err != nil {
+			return err
+		}
+		localBytes, err := os.ReadFile(configFilePath)
+		if err != nil {
+			return err
+		}
+		stateDir, err := config.StateDir()
+		if err != nil {
+			return err
+		}
+		dir, err := remote.Dir(stateDir, configFilePath)
+		if err != nil {
+			return err
+		}
+		source, baseBytes, err := remote.Load(dir)
+		if err != nil {
+			return err
+		}
+		url, err := cmd.Flags().GetString("url")
+		if err != nil {
+			return err
+		}
+		if url == "" && source != nil {
+			url = source.URL
+		}
+		if url == "" {
+			return errors.New("the remote source of the configuration file isn't known, pass it with --url")
+		}
+		check, err := cmd.Flags().GetBool("check")
+		if err != nil {
+			return err
+		}
+
+		verified, err := fetchConfig(cmd, url, dir)
+		if err != nil {
+			return err
+		}
+		if verified.offline {
+			if check {
+				return fmt.Errorf("couldn't reach %s, it can't be checked if the configuration file is up to date", url)
+			}
+			return fmt.Errorf("couldn't reach %s, the configuration file wasn't updated", url)
+		}
+		fetched := verified.body
+		remoteNode, err := parseNode(fetched)
+		if err != nil {
+			return fmt.Errorf("invalid remote configuration file: %w", err)
+		}
+		var remoteConfiguration config.Configuration
+		if err := remoteNode.Decode(&remoteConfiguration); err != nil {
+			return fmt.Errorf("invalid remote configuration file: %w", err)
+		}
+		if len(remoteConfiguration.Services) == 0 {
+			return fmt.Errorf("the remote configuration file at %s doesn't define any services", url)
+		}
+		sum := sha256.Sum256(fetched)
+		if source != nil && source.URL == url && baseBytes != nil && source.SHA256 == hex.EncodeToString(sum[:]) {
+			cmd.Printf("The configuration file is up to date with %s (fetched %s)\n", url, source.FetchedAt.Local().Format("2006-01-02 15:04"))
+			return nil
+		}
+
+		localNode, err := parseNode(localBytes)
+		if err != nil {
+			return err
+		}
+		beforeNode, err := parseNode(localBytes)
+		if err != nil {
+			return err
+		}
+		var baseNode *yaml.Node
+		if baseBytes != nil && source.URL == url {
+			if baseNode, err = parseNode(baseBytes); err != nil {
+				return err
+			}
+		}
+		var before config.Configuration
+		if err := localNode.Decode(&before); err != nil {
+			return err
+		}
+		notices := config.MergeRemote(baseNode, localNode, remoteNode)
+		var after config.Configuration
+		if err := localNode.Decode(&after); err != nil {
+			return fmt.Errorf("merged configuration is invalid: %w", err)
+		}
+		if err := after.Validate(); err != nil {
+			return fmt.Errorf("merged configuration is invalid: %w", err)
+		}
+
+		changes := config.Diff(before, after)
+		printChanges(cmd, changes)
+		var settings []string
+		for _, key := range config.ChangedSettings(beforeNode, localNode) {
+			if key != "services" {
+				settings = append(settings, key)
+			}
+		}
+		if len(settings) > 0 {
+			cmd.Printf("Changed settings: %s\n", strings.Join(settings, ", "))
+		}
+		conflicts := 0
+		for _, notice := range notices {
+			if notice.Conflict {
+				conflicts++
+				cmd.Printf("conflict: %s\n", notice)
+			}
+		}
+
+		if check {
+			if config.ChangedSettings(beforeNode, localNode) != nil {
+				return fmt.Errorf("the configuration file isn't up to date with %s, run tbm config pull", url)
+			}
+			return nil
+		}
+		if err := writeNode(configFilePath, localNode, localBytes); err != nil {
+			return err
+		}
+		if err := remote.Save(dir, url, fetched); err != nil {
+			return err
+		}
+		cmd.Printf("Updated %s from %s, the previous version is in %s.bak\n", configFilePath, url, configFilePath)
+		if conflicts > 0 {
+			cmd.Printf("%d conflicts kept your local values, check them with tbm validate\n", conflicts)
+		}
+		return nil
+	},
+}
+
+// printChanges shows the changes of services
+func printChanges(cmd *cobra.Command, changes []config.ServiceChange) {
+	if len(changes) == 0 {
+		cmd.Println("No services changed")
+		return
+	}
+	for _, change := range changes {
+		cmd.Printf("%s %s\n", change.Kind, change.Service)
+		for _, detail := range change.Details {
+			cmd.Printf("    %s\n", detail)
+		}
+	}
+}
+
+// configMigrateCmd represents the config migrate command
+var configMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Update the configuration file to the current format",
+	Long: fmt.Sprintf(`Update the configuration file and its local file to version %d of the format. Comments and the order of keys
+are kept, the previous files are kept with the suffix .bak. Files of older versions are still read, but new features
+may only be available in the current format.
+
+Use --check to fail if the files aren't in the current format.`, config.CurrentVersion),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		configFilePath, err := configPath(cmd)
+		if err != nil {
+			return err
+		}
+		check, err := cmd.Flags().GetBool("check")
+		if err != nil {
+			return err
+		}
+		outdated := 0
+		for _, path := range []string{configFilePath, config.LocalPath(configFilePath)} {
+			b, err := os.ReadFile(path)
+			if errors.Is(err, os.ErrNotExist) && path != configFilePath {
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			var document yaml.Node
+			if err := yaml.Unmarshal(b, &document); err != nil {
+				return fmt.Errorf("invalid configuration file %s: %w", path, err)
+			}
+			if len(document.Content) == 0 {
+				continue
+			}
+			node := document.Content[0]
+			version, err := config.Version(node)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			if version == config.CurrentVersion {
+				cmd.Printf("%s already has version %d\n", path, version)
+				continue
+			}
+			outdated++
+			applied, err := config.Migrate(node)
+			if err != nil {
+				return fmt.Errorf("%s: %w", path, err)
+			}
+			cmd.Printf("%s has version %d\n", path, version)
+			for _, description := range applied {
+				cmd.Printf("    %s\n", description)
+			}
+			if check {
+				continue
+			}
+			if err := writeNode(path, node, b); err != nil {
+				return err
+			}
+			cmd.Printf("Updated %s to version %d, the previous version is in %s.bak\n", path, config.CurrentVersion, path)
+		}
+		if check && outdated > 0 {
+			return fmt.Errorf("%d file(s) aren't in the current format, run tbm config migrate", outdated)
+		}
+		return nil
+	},
+}
+
+// configSchemaCmd represents the config schema command
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "Print the JSON Schema of the configuration file",
+	Long: `Print the JSON Schema of the configuration file. Editors with YAML support use it to complete and validate the
+configuration file, for example:
+
+tbm config schema > ~/.tbm.schema.json
+
+and add "# yaml-language-server: $schema=.tbm.schema.json" as first line of the configuration file.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		b, err := json.MarshalIndent(config.Schema(), "", "  ")
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintln(cmd.OutOrStdout(), string(b))
+		return err
+	},
+}
+
+// remoteCheckTimeout is how long tbm start waits for the remote configuration file, so services start quickly on slow
+// networks
+const remoteCheckTimeout = 3 * time.Second
+
+// checkRemoteConfig tells the user if the remote configuration file changed since it was pulled. It never fails, the
+// services are started with the configuration file in any case.
+func checkRemoteConfig(cmd *cobra.Command, configFilePath string) {
+	stateDir, err := config.StateDir()
+	if err != nil {
+		return
+	}
+	dir, err := remote.Dir(stateDir, configFilePath)
+	if err != nil {
+		return
+	}
+	source, _, err := remote.Load(dir)
+	if err != nil || source == nil {
+		return
+	}
+	verified, err := fetchVerified(cmd, remote.NewFetcher(remote.Options{Timeout: remoteCheckTimeout}), source.URL, dir, "")
+	if err != nil {
+		cmd.Printf("Couldn't check %s for updates: %s\n", source.URL, err)
+		return
+	}
+	sum := sha256.Sum256(verified.body)
+	if hex.EncodeToString(sum[:]) != source.SHA256 {
+		cmd.Printf("The remote configuration file %s changed since it was last pulled %s, run tbm config pull to update\n", source.URL, formatAge(time.Since(source.FetchedAt)))
+	}
+}
+
+// includeFetcher fetches included URLs like remote configuration files, they are verified and cached to be used
+// offline
+type includeFetcher struct {
+	cmd      *cobra.Command
+	fetcher  *remote.Fetcher
+	stateDir string
+}
+
+// newIncludeFetcher returns the fetcher of included URLs, it gives up quickly so services start on slow networks. With
+// --offline only the cached copies are used.
+func newIncludeFetcher(cmd *cobra.Command) (includeFetcher, error) {
+	stateDir, err := config.StateDir()
+	if err != nil {
+		return includeFetcher{}, err
+	}
+	options := remote.Options{Timeout: remoteCheckTimeout}
+	if flag := cmd.Flag("offline"); flag != nil {
+		options.Offline = flag.Value.String() == "true"
+	}
+	return includeFetcher{cmd: cmd, fetcher: remote.NewFetcher(options), stateDir: stateDir}, nil
+}
+
+// Fetch returns the included file at the URL without its signature block
+func (f includeFetcher) Fetch(rawURL string) ([]byte, error) {
+	sum := sha256.Sum256([]byte(rawURL))
+	dir := filepath.Join(f.stateDir, "remote", "includes", hex.EncodeToString(sum[:8]))
+	verified, err := fetchVerified(f.cmd, f.fetcher, rawURL, dir, "")
+	var unreachable *remote.UnreachableError
+	if errors.As(err, &unreachable) {
+		f.cmd.Printf("Skipping the services of %s, %s: %s\n", rawURL, config.ErrUnreachable, err)
+		return nil, fmt.Errorf("%w: %s", config.ErrUnreachable, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return verified.body, nil
+}
+
+// addFetchFlags adds the flags of fetching remote configuration files to a command
+func addFetchFlags(cmd *cobra.Command) {
+	cmd.Flags().String("token", "", fmt.Sprintf("Bearer token to fetch the remote configuration file, defaults to $%s", remote.EnvToken))
+	cmd.Flags().String("user", "", fmt.Sprintf("User and password (user:password) to fetch the remote configuration file with basic auth, defaults to $%s and $%s or .netrc", remote.EnvUser, remote.EnvPassword))
+	cmd.Flags().StringArray("header", nil, "Header (\"Name: value\") added to the request of the remote configuration file, can be repeated")
+	cmd.Flags().Duration("timeout", remote.DefaultTimeout, "How long fetching the remote configuration file may take")
+	cmd.Flags().String("max-size", "1MB", "Largest remote configuration file that is accepted")
+	cmd.Flags().String("sha256", "", "Expected SHA-256 checksum of the remote configuration file, it's rejected if it doesn't match")
+}
+
+// newFetcher returns a fetcher configured by the flags of addFetchFlags
+func newFetcher(cmd *cobra.Command) (*remote.Fetcher, error) {
+	var options remote.Options
+	var err error
+	if options.Token, err = cmd.Flags().GetString("token"); err != nil {
+		return nil, err
+	}
+	user, err := cmd.Flags().GetString("user")
+	if err != nil {
+		return nil, err
+	}
+	options.User, options.Password, _ = strings.Cut(user, ":")
+	headers, err := cmd.Flags().GetStringArray("header")
+	if err != nil {
+		return nil, err
+	}
+	options.Headers = make(http.Header)
+	for _, header := range headers {
+		name, value, err := remote.ParseHeader(header)
+		if err != nil {
+			return nil, err
+		}
+		options.Headers.Add(name, value)
+	}
+	if options.Timeout, err = cmd.Flags().GetDuration("timeout"); err != nil {
+		return nil, err
+	}
+	maxSize, err := cmd.Flags().GetString("max-size")
+	if err != nil {
+		return nil, err
+	}
+	size, err := config.ParseByteSize(maxSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --max-size: %w", err)
+	}
+	options.MaxSize = int64(size)
+	return remote.NewFetcher(options), nil
+}
+
+// parseNode parses a configuration file and migrates it to the current version, so files of all versions can be
+// merged. An empty document is an empty mapping.
+func parseNode(b []byte) (*yaml.Node, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(b, &document); err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+	if _, err := config.Migrate(document.Content[0]); err != nil {
+		return nil, err
+	}
+	return document.Content[0], nil
+}
+
+// writeNode writes a configuration file node, the previous content of the file is kept with the suffix .bak
+func writeNode(path string, node *yaml.Node, previous []byte) error {
+	b, err := encodeNode(node)
+	if err != nil {
+		return err
+	}
+	return writeConfig(path, b, previous)
+}
+
+// encodeNode encodes a configuration file node with the indentation of the files tbm writes
+func encodeNode(node *yaml.Node) ([]byte, error) {
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(4)
+	if err := enc.Encode(node); err != nil {
+		return nil, err
+	}
+	return b.Bytes(), nil
+}
+
+// writeConfig writes a configuration file, the previous content is kept with the suffix .bak if the file existed
+func writeConfig(path string, b []byte, previous []byte) error {
+	if previous != nil {
+		if err := os.WriteFile(path+".bak", previous, 0o600); err != nil {
+			return err
+		}
+	}
+	return os.WriteFile(path, b, 0o600)
+}
+
+func init() {
+	rootCmd.AddCommand(configCmd)
+	configCmd.AddCommand(configPullCmd)
+	configCmd.AddCommand(configMigrateCmd)
+	configCmd.AddCommand(configSchemaCmd)
+
+	var configFilePath string
+	hd, err := os.UserHomeDir()
+	if err == nil {
+		configFilePath = path.Join(hd, ".tbm.yaml")
+	} else {
+		configFilePath = "~/.tbm.yaml"
+	}
+	configCmd.PersistentFlags().String("config", configFilePath, "Location of the configuration file.")
+	configPullCmd.Flags().String("url", "", "URL of the remote configuration file, if it's another than the one it was downloaded from")
+	configPullCmd.Flags().Bool("check", false, "Only show the changes, fail if the configuration file isn't up to date")
+	addFetchFlags(configPullCmd)
+	configMigrateCmd.Flags().Bool("check", false, "Only show which files aren't in the current format and fail if there are any")
+}
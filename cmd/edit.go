@@ -0,0 +1,424 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/dewey/tbm/config"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+)
+
+// enableCmd represents the enable command
+var enableCmd = &cobra.Command{
+	Use:   "enable [service...]",
+	Short: "Enable services in the configuration file",
+	Long: `Enable services in the configuration file, so tbm start starts them. With --env, all services of the environment
+are enabled, or the given services if they belong to it. Use --local to change the local file instead.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setEnable(cmd, args, true)
+	},
+}
+
+// disableCmd represents the disable command
+var disableCmd = &cobra.Command{
+	Use:   "disable [service...]",
+	Short: "Disable services in the configuration file",
+	Long: `Disable services in the configuration file, so tbm start doesn't start them. With --env, all services of the
+environment are disabled, or the given services if they belong to it. Use --local to change the local file instead.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return setEnable(cmd, args, false)
+	},
+}
+
+// addCmd represents the add command
+var addCmd = &cobra.Command{
+	Use:   "add <service>",
+	Short: "Add a service to the configuration file",
+	Long: `Add a service to the configuration file. Without --command, the command, the environment and the values of the
+variables the command uses are asked for in the terminal. Variables are passed with --var name=value.
+
+For example:
+tbm add reporting-db --command "cloud_sql_proxy -instances=reporting=tcp:{{.port}}" --env prod --var port=10010`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		configuration, _, err := loadConfig(cmd)
+		if err != nil {
+			return err
+		}
+		if _, ok := configuration.Services[name]; ok {
+			return fmt.Errorf("service %s already exists, change it with tbm set", name)
+		}
+		service, values, err := newService(cmd)
+		if err != nil {
+			return err
+		}
+		_, err = editConfig(cmd, []string{name}, func(node *yaml.Node) error {
+			var value yaml.Node
+			if err := value.Encode(service); err != nil {
+				return err
+			}
+			if err := config.SetValue(node, []string{"services", name}, &value); err != nil {
+				return err
+			}
+			// Values are parsed as YAML, so a variable can be a mapping with settings like {from: store, name: pw}
+			for _, variable := range values {
+				if err := config.SetServiceValue(node, name, "variables."+variable[0], variable[1]); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		cmd.Printf("Added %s\n", name)
+		return nil
+	},
+}
+
+// removeCmd represents the remove command
+var removeCmd = &cobra.Command{
+	Use:   "remove <service...>",
+	Short: "Remove services from the configuration file",
+	Long: `Remove services from the configuration file, or from the local file with --local. Services that other services
+depend on can't be removed.`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		configuration, _, err := loadConfig(cmd)
+		if err != nil {
+			return err
+		}
+		for _, name := range args {
+			for other, service := range configuration.Services {
+				for _, dependency := range service.DependsOn {
+					if dependency == name && !contains(args, other) {
+						return fmt.Errorf("can't remove %s, %s depends on it", name, other)
+					}
+				}
+			}
+		}
+		_, err = editConfig(cmd, nil, func(node *yaml.Node) error {
+			for _, name := range args {
+				if err := config.DeleteValue(node, []string{"services", name}); err != nil {
+					return fmt.Errorf("service %s isn't defined in this file", name)
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		cmd.Printf("Removed %s\n", strings.Join(args, ", "))
+		return nil
+	},
+}
+
+// setCmd represents the set command
+var setCmd = &cobra.Command{
+	Use:   "set <service> <key> <value>",
+	Short: "Change a setting of a service in the configuration file",
+	Long: `Change a setting of a service in the configuration file, or in the local file with --local. The key is the
+path of the setting, the value is YAML.
+
+For example:
+tbm set reporting-db variables.port 10010
+tbm set reporting-db depends_on "[vpn]"`,
+	Args: cobra.ExactArgs(3),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name, key, value := args[0], args[1], args[2]
+		configuration, _, err := loadConfig(cmd)
+		if err != nil {
+			return err
+		}
+		if _, ok := configuration.Services[name]; !ok {
+			return fmt.Errorf("service %s doesn't exist, add it with tbm add", name)
+		}
+		_, err = editConfig(cmd, []string{name}, func(node *yaml.Node) error {
+			return config.SetServiceValue(node, name, key, value)
+		})
+		if err != nil {
+			return err
+		}
+		cmd.Printf("Set %s of %s to %s\n", key, name, value)
+		return nil
+	},
+}
+
+// setEnable enables or disables the services, or the services of the environment of --env
+func setEnable(cmd *cobra.Command, names []string, enable bool) error {
+	environment, err := cmd.Flags().GetString("env")
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 && environment == "" {
+		return errors.New("pass the services or an environment with --env")
+	}
+	configuration, _, err := loadConfig(cmd)
+	if err != nil {
+		return err
+	}
+	for _, name := range names {
+		service, ok := configuration.Services[name]
+		if !ok {
+			return fmt.Errorf("service %s doesn't exist", name)
+		}
+		if environment != "" && service.Environment != environment {
+			return fmt.Errorf("service %s belongs to environment %q, not %s", name, service.Environment, environment)
+		}
+	}
+	if len(names) == 0 {
+		for name, service := range configuration.Services {
+			if service.Environment == environment {
+				names = append(names, name)
+			}
+		}
+		if len(names) == 0 {
+			return fmt.Errorf("there are no services in environment %s", environment)
+		}
+		sort.Strings(names)
+	}
+
+	changed, err := editConfig(cmd, names, func(node *yaml.Node) error {
+		for _, name := range names {
+			if err := config.SetServiceValue(node, name, "enable", fmt.Sprint(enable)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	state := "Disabled"
+	if enable {
+		state = "Enabled"
+	}
+	cmd.Printf("%s %s\n", state, strings.Join(names, ", "))
+	for _, name := range names {
+		if changed.Services[name].Enable != enable {
+			cmd.Printf("The local file still sets enable of %s to %t, change it with --local\n", name, !enable)
+		}
+	}
+	return nil
+}
+
+// editConfig changes the configuration file, or the local file with --local, with edit. The file is migrated to the
+// current version first. The changed configuration is checked before the file is written: it may not have new
+// duplicate ports and the given services have to be valid if they are enabled. Comments, the order of keys and
+// anchors are kept, and the previous file is kept with the suffix .bak.
+func editConfig(cmd *cobra.Command, services []string, edit func(node *yaml.Node) error) (config.Configuration, error) {
+	local, err := cmd.Flags().GetBool("local")
+	if err != nil {
+		return config.Configuration{}, err
+	}
+	return editConfigFile(cmd, local, services, edit)
+}
+
+// editConfigFile changes the configuration file, or the local file if local is set, like editConfig
+func editConfigFile(cmd *cobra.Command, local bool, services []string, edit func(node *yaml.Node) error) (config.Configuration, error) {
+	configFilePath, err := configPath(cmd)
+	if err != nil {
+		return config.Configuration{}, err
+	}
+	path := configFilePath
+	if local {
+		path = config.LocalPath(configFilePath)
+	}
+	previous, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return config.Configuration{}, err
+	}
+
+	var document yaml.Node
+	if err := yaml.Unmarshal(previous, &document); err != nil {
+		return config.Configuration{}, fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if len(document.Content) > 0 {
+		node = document.Content[0]
+	}
+	applied, err := config.Migrate(node)
+	if err != nil {
+		return config.Configuration{}, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := edit(node); err != nil {
+		return config.Configuration{}, err
+	}
+	b, err := encodeNode(node)
+	if err != nil {
+		return config.Configuration{}, err
+	}
+
+	fetcher, err := newIncludeFetcher(cmd)
+	if err != nil {
+		return config.Configuration{}, err
+	}
+	changed, _, err := config.LoadChanged(configFilePath, path, b, fetcher)
+	if err != nil {
+		return config.Configuration{}, fmt.Errorf("nothing was changed, the configuration would be invalid: %w", err)
+	}
+	if problems := changed.Problems(); len(problems) > 0 {
+		// Problems the configuration already had don't keep it from being changed, for example to fix them
+		existing := make(map[config.Problem]bool)
+		if current, _, err := config.Load(configFilePath, fetcher); err == nil {
+			for _, problem := range current.Problems() {
+				existing[problem] = true
+			}
+		}
+		for _, problem := range problems {
+			if !existing[problem] {
+				return config.Configuration{}, fmt.Errorf("nothing was changed, the configuration would be invalid: %w", problem)
+			}
+		}
+	}
+	for _, name := range services {
+		if service, ok := changed.Services[name]; ok && service.Enable {
+			if err := service.Validate(); err != nil {
+				return config.Configuration{}, fmt.Errorf("nothing was changed, service %s would be invalid: %w", name, err)
+			}
+		}
+	}
+
+	if err := writeConfig(path, b, previous); err != nil {
+		return config.Configuration{}, err
+	}
+	if len(applied) > 0 {
+		cmd.Printf("Updated %s to version %d of the format\n", path, config.CurrentVersion)
+	}
+	if previous != nil {
+		cmd.Printf("Saved %s, the previous version is in %s.bak\n", path, path)
+	} else {
+		cmd.Printf("Created %s\n", path)
+	}
+	return changed, nil
+}
+
+// newService returns the service of the flags of the add command, missing values are asked for in a terminal. The
+// variables are returned separately as name and value pairs in their order.
+func newService(cmd *cobra.Command) (config.Service, [][2]string, error) {
+	var service config.Service
+	var err error
+	if service.Command, err = cmd.Flags().GetString("command"); err != nil {
+		return service, nil, err
+	}
+	if service.Environment, err = cmd.Flags().GetString("env"); err != nil {
+		return service, nil, err
+	}
+	if service.Type, err = cmd.Flags().GetString("type"); err != nil {
+		return service, nil, err
+	}
+	if service.DependsOn, err = cmd.Flags().GetStringSlice("depends-on"); err != nil {
+		return service, nil, err
+	}
+	disable, err := cmd.Flags().GetBool("disable")
+	if err != nil {
+		return service, nil, err
+	}
+	service.Enable = !disable
+	vars, err := cmd.Flags().GetStringArray("var")
+	if err != nil {
+		return service, nil, err
+	}
+	var values [][2]string
+	for _, v := range vars {
+		name, value, ok := strings.Cut(v, "=")
+		if !ok || name == "" {
+			return service, nil, fmt.Errorf("invalid variable %q, use name=value", v)
+		}
+		values = append(values, [2]string{name, value})
+	}
+	if service.Command != "" {
+		return service, values, nil
+	}
+
+	if !isatty.IsTerminal(os.Stdin.Fd()) && !isatty.IsCygwinTerminal(os.Stdin.Fd()) {
+		return service, nil, errors.New("pass the command of the service with --command")
+	}
+	reader := bufio.NewReader(cmd.InOrStdin())
+	for service.Command == "" {
+		if service.Command, err = ask(cmd, reader, "Command, variables are used as {{.name}}", ""); err != nil {
+			return service, nil, err
+		}
+	}
+	if service.Environment, err = ask(cmd, reader, "Environment", service.Environment); err != nil {
+		return service, nil, err
+	}
+	placeholders, err := config.Placeholders(service.Command)
+	if err != nil {
+		return service, nil, fmt.Errorf("invalid command: %w", err)
+	}
+	for _, placeholder := range placeholders {
+		given := false
+		for _, value := range values {
+			given = given || value[0] == placeholder
+		}
+		if given {
+			continue
+		}
+		value, err := ask(cmd, reader, "Value of "+placeholder, "")
+		if err != nil {
+			return service, nil, err
+		}
+		values = append(values, [2]string{placeholder, value})
+	}
+	return service, values, nil
+}
+
+// ask asks a question in the terminal, the default is used if the answer is empty
+func ask(cmd *cobra.Command, reader *bufio.Reader, question string, defaultAnswer string) (string, error) {
+	if defaultAnswer != "" {
+		question = fmt.Sprintf("%s [%s]", question, defaultAnswer)
+	}
+	cmd.Print(question + ": ")
+	answer, err := reader.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	answer = strings.TrimSpace(answer)
+	if answer == "" {
+		return defaultAnswer, nil
+	}
+	return answer, nil
+}
+
+// contains returns true if the list contains the value
+func contains(list []string, value string) bool {
+	for _, v := range list {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+func init() {
+	rootCmd.AddCommand(enableCmd, disableCmd, addCmd, removeCmd, setCmd)
+
+	var configFilePath string
+	hd, err := os.UserHomeDir()
+	if err == nil {
+		configFilePath = path.Join(hd, ".tbm.yaml")
+	} else {
+		configFilePath = "~/.tbm.yaml"
+	}
+	for _, c := range []*cobra.Command{enableCmd, disableCmd, addCmd, removeCmd, setCmd} {
+		c.Flags().String("config", configFilePath, "Location of the configuration file.")
+		c.Flags().Bool("local", false, "Change the local file instead of the configuration file")
+	}
+	enableCmd.Flags().String("env", "", "Only services of this environment, all of them if no services are given")
+	disableCmd.Flags().String("env", "", "Only services of this environment, all of them if no services are given")
+	addCmd.Flags().String("command", "", "Command of the service, variables are used as {{.name}}")
+	addCmd.Flags().String("env", "", "Environment of the service")
+	addCmd.Flags().String("type", "", "Type of the service, daemon (default) or oneshot")
+	addCmd.Flags().StringSlice("depends-on", nil, "Services that have to be ready before the service is started")
+	addCmd.Flags().StringArray("var", nil, "Variable of the command as name=value, can be repeated")
+	addCmd.Flags().Bool("disable", false, "Add the service disabled")
+}
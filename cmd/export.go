@@ -0,0 +1,260 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"github.com/dewey/tbm/config"
+	"github.com/dewey/tbm/secret"
+	"github.com/spf13/cobra"
+	"os"
+	"path"
+	"path/filepath"
+)
+
+// exportCmd represents the export command
+var exportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Write the services in the format of other tools",
+}
+
+// exportProcfileCmd represents the export procfile command
+var exportProcfileCmd = &cobra.Command{
+	Use:   "procfile",
+	Short: "Write the enabled services as a Procfile for foreman or goreman",
+	Long: `Write the enabled services as a Procfile for foreman or goreman, with the values of the variables in their
+commands. Values of secret variables and of providers are written to a .env file next to the Procfile that only the
+user can read, the commands reference them like $TBM_DB_PASSWORD. Prompted variables need an answer from tbm start.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		configuration, _, err := loadConfig(cmd)
+		if err != nil {
+			return err
+		}
+		names, err := exportedServices(cmd, configuration)
+		if err != nil {
+			return err
+		}
+		var b bytes.Buffer
+		secrets, err := configuration.WriteProcfile(&b, names)
+		if err != nil {
+			return err
+		}
+		if len(secrets) > 0 {
+			if err := writeDotenv(cmd, secrets); err != nil {
+				return err
+			}
+		}
+		return writeExport(cmd, b.Bytes())
+	},
+}
+
+// exportSystemdCmd represents the export systemd command
+var exportSystemdCmd = &cobra.Command{
+	Use:   "systemd",
+	Short: "Write the enabled services as systemd user units",
+	Long: `Write the enabled services as systemd user units, so they are started at login without tbm running in a
+terminal. Every service gets a unit named tbm-<service>-<environment>.service, scheduled services get a timer as well,
+and a target named tbm-<environment>.target starts all of them. Failed services are restarted, restart_every and
+max_lifetime limit how long they run, hooks become ExecStartPre, ExecStartPost, ExecStop and ExecStopPost commands.
+
+The commands are run in the directory given with --working-dir, the current directory by default. Values of secret
+variables and of providers are written to an environment file of the unit that only the user can read, prompted
+variables need an answer from tbm start.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		configuration, _, err := loadConfig(cmd)
+		if err != nil {
+			return err
+		}
+		names, err := exportedServices(cmd, configuration)
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			return errors.New("there are no services to export")
+		}
+		options, err := systemdOptions(cmd)
+		if err != nil {
+			return err
+		}
+		units, err := configuration.SystemdUnits(names, options)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(options.Dir, 0o755); err != nil {
+			return err
+		}
+		for _, unit := range units {
+			file := filepath.Join(options.Dir, unit.Name)
+			mode := os.FileMode(0o644)
+			if unit.Secret {
+				mode = 0o600
+			}
+			if err := writeFile(file, []byte(unit.Content), mode); err != nil {
+				return err
+			}
+			cmd.Printf("Wrote %s\n", file)
+		}
+		cmd.Printf("Start the services now and at every login with:\n\n")
+		cmd.Printf("    systemctl --user daemon-reload\n    systemctl --user enable --now %s\n", options.Target)
+		return nil
+	},
+}
+
+// systemdOptions returns the options of the exported units given by the flags
+func systemdOptions(cmd *cobra.Command) (config.SystemdOptions, error) {
+	var options config.SystemdOptions
+	environment, err := cmd.Flags().GetString("env")
+	if err != nil {
+		return options, err
+	}
+	options.Target = "tbm.target"
+	if environment != "" {
+		options.Target = "tbm-" + environment + ".target"
+	}
+	if options.Source, err = configPath(cmd); err != nil {
+		return options, err
+	}
+	if options.Dir, err = cmd.Flags().GetString("dir"); err != nil {
+		return options, err
+	}
+	if options.Dir, err = filepath.Abs(options.Dir); err != nil {
+		return options, err
+	}
+	if options.WorkingDirectory, err = cmd.Flags().GetString("working-dir"); err != nil {
+		return options, err
+	}
+	if options.WorkingDirectory == "" {
+		if options.WorkingDirectory, err = os.Getwd(); err != nil {
+			return options, err
+		}
+	}
+	options.WorkingDirectory, err = filepath.Abs(options.WorkingDirectory)
+	return options, err
+}
+
+// exportedServices returns the services that are exported with the flags of the export commands, in the order of the
+// configuration file. Variables from providers are resolved. The export fails if a service can't be exported, unless
+// --skip-unexportable is set and it's skipped with a note.
+func exportedServices(cmd *cobra.Command, configuration config.Configuration) ([]string, error) {
+	environment, err := cmd.Flags().GetString("env")
+	if err != nil {
+		return nil, err
+	}
+	all, err := cmd.Flags().GetBool("all")
+	if err != nil {
+		return nil, err
+	}
+	skip, err := cmd.Flags().GetBool("skip-unexportable")
+	if err != nil {
+		return nil, err
+	}
+	store, err := secret.DefaultStore()
+	if err != nil {
+		return nil, err
+	}
+	resolver := secret.NewResolver(store)
+	var names []string
+	for _, name := range configuration.ServiceNames() {
+		service := configuration.Services[name]
+		if (!service.Enable && !all) || (environment != "" && service.Environment != environment) {
+			continue
+		}
+		err := service.Validate()
+		if err == nil {
+			service, err = service.Resolve(resolver)
+		}
+		if err == nil {
+			_, err = service.ExportedCommand(service.Command, func(name string) string { return name }, make(map[string]string))
+		}
+		if err != nil {
+			if !skip {
+				return nil, fmt.Errorf("can't export %s: %w, use --skip-unexportable to export the other services", name, err)
+			}
+			cmd.Printf("Skipped %s, %s\n", name, err)
+			continue
+		}
+		configuration.Services[name] = service
+		names = append(names, name)
+	}
+	return names, nil
+}
+
+// writeDotenv writes the values of secret variables of the exported Procfile to the .env file next to it. Files that
+// weren't written by tbm aren't overwritten.
+func writeDotenv(cmd *cobra.Command, secrets map[string]string) error {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+	file := filepath.Join(filepath.Dir(output), ".env")
+	if b, err := os.ReadFile(file); err == nil && !bytes.HasPrefix(b, []byte(config.GeneratedDotenv)) {
+		return fmt.Errorf("%s already exists and wasn't written by tbm, the secret variables can't be written to it", file)
+	}
+	source, err := configPath(cmd)
+	if err != nil {
+		return err
+	}
+	var b bytes.Buffer
+	if err := config.WriteDotenv(&b, source, secrets); err != nil {
+		return err
+	}
+	if err := writeFile(file, b.Bytes(), 0o600); err != nil {
+		return err
+	}
+	cmd.Printf("Wrote the secret variables to %s\n", file)
+	return nil
+}
+
+// writeFile writes a file with the mode, also if it already exists with another one
+func writeFile(file string, b []byte, mode os.FileMode) error {
+	if err := os.WriteFile(file, b, mode); err != nil {
+		return err
+	}
+	return os.Chmod(file, mode)
+}
+
+// writeExport writes an exported file to the path of --output, or to standard output
+func writeExport(cmd *cobra.Command, b []byte) error {
+	output, err := cmd.Flags().GetString("output")
+	if err != nil {
+		return err
+	}
+	if output == "" {
+		_, err := cmd.OutOrStdout().Write(b)
+		return err
+	}
+	if err := os.WriteFile(output, b, 0o644); err != nil {
+		return err
+	}
+	cmd.Printf("Wrote %s\n", output)
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+	exportCmd.AddCommand(exportProcfileCmd, exportSystemdCmd)
+
+	var configFilePath string
+	hd, err := os.UserHomeDir()
+	if err == nil {
+		configFilePath = path.Join(hd, ".tbm.yaml")
+	} else {
+		configFilePath = "~/.tbm.yaml"
+	}
+	exportCmd.PersistentFlags().String("config", configFilePath, "Location of the configuration file.")
+	exportCmd.PersistentFlags().String("env", "", "Only export the services of this environment")
+	exportCmd.PersistentFlags().Bool("all", false, "Export disabled services as well")
+	exportCmd.PersistentFlags().Bool("skip-unexportable", false, "Skip services that can't be exported instead of failing")
+	exportProcfileCmd.Flags().StringP("output", "o", "", "File the Procfile is written to instead of standard output")
+
+	var unitDir string
+	if dir, err := os.UserConfigDir(); err == nil {
+		unitDir = filepath.Join(dir, "systemd", "user")
+	} else {
+		unitDir = "~/.config/systemd/user"
+	}
+	exportSystemdCmd.Flags().String("dir", unitDir, "Directory the unit files are written to")
+	exportSystemdCmd.Flags().String("working-dir", "", "Directory the commands are run in, the current directory if not set")
+}
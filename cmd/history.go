@@ -0,0 +1,142 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/dewey/tbm/config"
+	"github.com/dewey/tbm/history"
+	"github.com/spf13/cobra"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+)
+
+// historyCmd represents the history command
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Show when services were started and stopped",
+	Long: `Show the local history of services started by tbm. Every start and stop of a service is recorded with the
+user, environment, port and command (sensitive variables are redacted). The history ends with the total time services
+were running per environment.
+
+For example:
+tbm history --env prod --since 168h`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var filter history.Filter
+		var err error
+		filter.Environment, err = cmd.Flags().GetString("env")
+		if err != nil {
+			return err
+		}
+		filter.Service, err = cmd.Flags().GetString("service")
+		if err != nil {
+			return err
+		}
+		now := time.Now()
+		since, err := cmd.Flags().GetString("since")
+		if err != nil {
+			return err
+		}
+		if filter.Since, err = parseTime(since, now); err != nil {
+			return fmt.Errorf("invalid --since value: %w", err)
+		}
+		until, err := cmd.Flags().GetString("until")
+		if err != nil {
+			return err
+		}
+		if filter.Until, err = parseTime(until, now); err != nil {
+			return fmt.Errorf("invalid --until value: %w", err)
+		}
+
+		stateDir, err := config.StateDir()
+		if err != nil {
+			return err
+		}
+		entries, err := history.Read(stateDir)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "START\tEND\tDURATION\tUSER\tSERVICE\tENVIRONMENT\tPORT\tEXIT\tCOMMAND")
+		totals := make(map[string]time.Duration)
+		for _, session := range history.Sessions(entries) {
+			if !filter.Match(session) {
+				continue
+			}
+			end, exit := "running", "-"
+			if session.Stop != nil {
+				end = session.Stop.Time.Format("2006-01-02 15:04:05")
+				exit = strconv.Itoa(session.Stop.ExitStatus)
+			}
+			port := "-"
+			if session.Start.Port > 0 {
+				port = strconv.Itoa(int(session.Start.Port))
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n",
+				session.Start.Time.Format("2006-01-02 15:04:05"), end, session.Duration().Round(time.Second),
+				session.Start.User, session.Start.Service, session.Start.Environment, port, exit, session.Start.Command)
+			totals[session.Start.Environment] += clippedDuration(session, filter.Since, filter.Until, now)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if len(totals) == 0 {
+			return nil
+		}
+		var environments []string
+		for environment := range totals {
+			environments = append(environments, environment)
+		}
+		sort.Strings(environments)
+		cmd.Println()
+		cmd.Println("Total time services were running per environment:")
+		for _, environment := range environments {
+			cmd.Printf("  %s: %s\n", environment, totals[environment].Round(time.Second))
+		}
+		return nil
+	},
+}
+
+// clippedDuration returns the part of a session's duration within the given time range
+func clippedDuration(session history.Session, since time.Time, until time.Time, now time.Time) time.Duration {
+	start, end := session.Start.Time, session.End()
+	if end.IsZero() {
+		end = now
+	}
+	if !since.IsZero() && start.Before(since) {
+		start = since
+	}
+	if !until.IsZero() && end.After(until) {
+		end = until
+	}
+	if end.Before(start) {
+		return 0
+	}
+	return end.Sub(start)
+}
+
+// parseTime accepts a duration relative to now ("24h"), a date or a date with time. An empty string is the zero time.
+func parseTime(value string, now time.Time) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q is neither a duration like 24h nor a date like 2006-01-02", value)
+}
+
+func init() {
+	rootCmd.AddCommand(historyCmd)
+	historyCmd.Flags().String("env", "", "Only show services of this environment")
+	historyCmd.Flags().String("service", "", "Only show this service")
+	historyCmd.Flags().String("since", "", "Only show sessions since a duration ago (24h) or a date (2006-01-02 15:04)")
+	historyCmd.Flags().String("until", "", "Only show sessions until a duration ago (24h) or a date (2006-01-02 15:04)")
+}
@@ -0,0 +1,153 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dewey/tbm/config"
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// importCmd represents the import command
+var importCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Add the services of a Procfile or a docker compose file to the configuration file",
+}
+
+// importProcfileCmd represents the import procfile command
+var importProcfileCmd = &cobra.Command{
+	Use:   "procfile <Procfile>",
+	Short: "Add the entries of a Procfile of foreman or goreman as services",
+	Long: `Add the entries of a Procfile of foreman or goreman as services to the configuration file. $PORT in a command
+becomes the port variable, with the port foreman would have used: 5000 for the first entry, 5100 for the second and so
+on. Use --base-port to start at another port.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		defaults, err := importDefaults(cmd)
+		if err != nil {
+			return err
+		}
+		if defaults.BasePort, err = cmd.Flags().GetInt("base-port"); err != nil {
+			return err
+		}
+		f, err := os.Open(args[0])
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		imported, err := config.ParseProcfile(f, defaults)
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		return importServices(cmd, imported)
+	},
+}
+
+// importComposeCmd represents the import compose command
+var importComposeCmd = &cobra.Command{
+	Use:   "compose <docker-compose.yml>",
+	Short: "Add the services of a docker compose file as services",
+	Long: `Add the services of a docker compose file to the configuration file. Every service is started with
+"docker compose run" from the compose file, so images, builds, volumes and environment stay in the compose file.
+Published ports become variables, the first one is port and the others are named by the port in the container like
+port_443. Dependencies between the services are kept.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		defaults, err := importDefaults(cmd)
+		if err != nil {
+			return err
+		}
+		composePath, err := filepath.Abs(args[0])
+		if err != nil {
+			return err
+		}
+		b, err := os.ReadFile(composePath)
+		if err != nil {
+			return err
+		}
+		imported, notes, err := config.ParseCompose(b, composePath, defaults)
+		if err != nil {
+			return fmt.Errorf("%s: %w", args[0], err)
+		}
+		for _, note := range notes {
+			cmd.Println(note)
+		}
+		return importServices(cmd, imported)
+	},
+}
+
+// importDefaults returns the settings of imported services given by the flags
+func importDefaults(cmd *cobra.Command) (config.ImportDefaults, error) {
+	var defaults config.ImportDefaults
+	var err error
+	if defaults.Environment, err = cmd.Flags().GetString("env"); err != nil {
+		return defaults, err
+	}
+	disable, err := cmd.Flags().GetBool("disable")
+	if err != nil {
+		return defaults, err
+	}
+	defaults.Enable = !disable
+	return defaults, nil
+}
+
+// importServices adds the imported services to the configuration file, it's created if it doesn't exist yet
+func importServices(cmd *cobra.Command, imported config.Configuration) error {
+	configFilePath, err := configPath(cmd)
+	if err != nil {
+		return err
+	}
+	var existing config.Configuration
+	if _, err := os.Stat(configFilePath); err == nil {
+		if existing, _, err = loadConfig(cmd); err != nil {
+			return err
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	names := imported.ServiceNames()
+	for _, name := range names {
+		if _, ok := existing.Services[name]; ok {
+			return fmt.Errorf("service %s already exists, remove it with tbm remove first", name)
+		}
+	}
+	_, err = editConfig(cmd, names, func(node *yaml.Node) error {
+		for _, name := range names {
+			var value yaml.Node
+			if err := value.Encode(imported.Services[name]); err != nil {
+				return err
+			}
+			if err := config.SetValue(node, []string{"services", name}, &value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	cmd.Printf("Imported %s\n", strings.Join(names, ", "))
+	return nil
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+	importCmd.AddCommand(importProcfileCmd, importComposeCmd)
+
+	var configFilePath string
+	hd, err := os.UserHomeDir()
+	if err == nil {
+		configFilePath = path.Join(hd, ".tbm.yaml")
+	} else {
+		configFilePath = "~/.tbm.yaml"
+	}
+	importCmd.PersistentFlags().String("config", configFilePath, "Location of the configuration file.")
+	importCmd.PersistentFlags().Bool("local", false, "Add the services to the local file instead of the configuration file")
+	importCmd.PersistentFlags().String("env", "", "Environment of the imported services")
+	importCmd.PersistentFlags().Bool("disable", false, "Import the services disabled")
+	importProcfileCmd.Flags().Int("base-port", config.DefaultBasePort, "Port of the first entry, the next ones get ports in steps of 100")
+}
@@ -1,10 +1,11 @@
 package cmd
 
 import (
+	"fmt"
 	"github.com/dewey/tbm/config"
+	"github.com/dewey/tbm/remote"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
-	"net/http"
 	"os"
 	"path"
 )
@@ -20,29 +21,46 @@ var initCmd = &cobra.Command{
 		// Fetch remote configuration file if provided by user, otherwise create default example configuration
 		configURL := cmd.Flag("config-url")
 		if configURL.Value.String() != "" {
-			resp, err := http.Get(configURL.Value.String())
+			hd, err := os.UserHomeDir()
 			if err != nil {
 				return err
 			}
-			if err := yaml.NewDecoder(resp.Body).Decode(&cfg); err != nil {
+			configFilePath := path.Join(hd, ".tbm.yaml")
+			stateDir, err := config.StateDir()
+			if err != nil {
 				return err
 			}
-			b, err := yaml.Marshal(cfg)
+			dir, err := remote.Dir(stateDir, configFilePath)
 			if err != nil {
 				return err
 			}
-			hd, err := os.UserHomeDir()
+			verified, err := fetchConfig(cmd, configURL.Value.String(), dir)
+			if err != nil {
+				return err
+			}
+			fetched := verified.body
+			if err := yaml.Unmarshal(fetched, &cfg); err != nil {
+				return fmt.Errorf("invalid remote configuration file: %w", err)
+			}
+			if len(cfg.Services) == 0 {
+				return fmt.Errorf("the remote configuration file at %s doesn't define any services", configURL.Value.String())
+			}
+			cfg.Version = config.CurrentVersion
+			b, err := yaml.Marshal(cfg)
 			if err != nil {
 				return err
 			}
-			configFilePath := path.Join(hd, ".tbm.yaml")
 			existed, err := config.Create(configFilePath, b)
 			if err != nil {
 				return err
 			}
 			if existed {
-				cmd.Printf("Config file already exists in %s. Manually delete it to recreate the example config file.\n", configFilePath)
+				cmd.Printf("Config file already exists in %s. Manually delete it to recreate the example config file, or use `tbm config pull --url` to update it.\n", configFilePath)
 			} else {
+				// Remember where the file came from, so `tbm config pull` can update it
+				if err := remote.Save(dir, configURL.Value.String(), fetched); err != nil {
+					return err
+				}
 				cmd.Printf("Successfully initialized based on remote configuration file downloaded to: %s. Use `tbm start` to give it a try based on the config file.\n", configFilePath)
 			}
 		} else {
@@ -62,7 +80,7 @@ var initCmd = &cobra.Command{
 				Environment: "stage",
 				Enable:      true,
 			}
-			cfg = config.Configuration{Services: services}
+			cfg = config.Configuration{Version: config.CurrentVersion, Services: services}
 			b, err := yaml.Marshal(cfg)
 			if err != nil {
 				return err
@@ -84,4 +102,5 @@ var initCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(initCmd)
 	initCmd.Flags().String("config-url", "", "Provide a URL hosting a configuration file. This will be stored at the default configuration location.")
+	addFetchFlags(initCmd)
 }
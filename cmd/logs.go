@@ -0,0 +1,234 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"github.com/dewey/tbm/config"
+	"github.com/dewey/tbm/log"
+	"github.com/dewey/tbm/proc"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// logsCmd represents the logs command
+var logsCmd = &cobra.Command{
+	Use:   "logs <service>",
+	Short: "Show the logs of a service",
+	Long: `Show the output of a service. If tbm is running the service, its recent lines are shown from memory and new
+lines are shown right away with --follow. Otherwise the log files tbm writes for every service in the state directory
+are read, including rotated files. Use --files to always read the log files.
+
+For example:
+tbm logs db --env prod --since 10m --grep "connection refused"`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		environment, err := cmd.Flags().GetString("env")
+		if err != nil {
+			return err
+		}
+		follow, err := cmd.Flags().GetBool("follow")
+		if err != nil {
+			return err
+		}
+		since, err := cmd.Flags().GetString("since")
+		if err != nil {
+			return err
+		}
+		sinceTime, err := parseTime(since, time.Now())
+		if err != nil {
+			return fmt.Errorf("invalid --since value: %w", err)
+		}
+		pattern, err := cmd.Flags().GetString("grep")
+		if err != nil {
+			return err
+		}
+		var grep *regexp.Regexp
+		if pattern != "" {
+			if grep, err = regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("invalid --grep pattern: %w", err)
+			}
+		}
+
+		files, err := cmd.Flags().GetBool("files")
+		if err != nil {
+			return err
+		}
+
+		stateDir, err := config.StateDir()
+		if err != nil {
+			return err
+		}
+		filter := logFilter{since: sinceTime, grep: grep}
+		if !files {
+			err := filter.control(cmd.OutOrStdout(), proc.NewControlClient(proc.SocketPath(stateDir)), args[0], environment, follow)
+			if !errors.Is(err, proc.ErrNotRunning) && !errors.Is(err, errNotInControl) {
+				return err
+			}
+		}
+
+		path, err := serviceLogFile(filepath.Join(stateDir, "logs"), args[0], environment)
+		if err != nil {
+			return err
+		}
+		var offset int64
+		for _, file := range log.RotatedFiles(path) {
+			if offset, err = filter.copy(cmd.OutOrStdout(), file, 0); err != nil {
+				return err
+			}
+		}
+		if !follow {
+			return nil
+		}
+		return filter.follow(cmd.OutOrStdout(), path, offset)
+	},
+}
+
+// serviceLogFile finds the log file of a service. Without environment, the service must only have logs in one.
+func serviceLogFile(dir string, service string, environment string) (string, error) {
+	if environment != "" {
+		path := log.FilePath(dir, service, environment)
+		if len(log.RotatedFiles(path)) == 0 {
+			return "", fmt.Errorf("no logs of service %s in environment %s", service, environment)
+		}
+		return path, nil
+	}
+	matches, err := filepath.Glob(log.FilePath(dir, service, "*"))
+	if err != nil {
+		return "", err
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("no logs of service %s", service)
+	case 1:
+		return matches[0], nil
+	}
+	var environments []string
+	for _, match := range matches {
+		environments = append(environments, filepath.Base(filepath.Dir(match)))
+	}
+	sort.Strings(environments)
+	return "", fmt.Errorf("service %s has logs in the environments %s, select one with --env", service, strings.Join(environments, ", "))
+}
+
+// logFilter selects the lines of log files that are printed
+type logFilter struct {
+	since time.Time
+	grep  *regexp.Regexp
+}
+
+// match returns true if a line should be printed. Lines without time, like continued lines, only pass if the
+// previous line did.
+func (f logFilter) match(line string, previous bool) bool {
+	if t, ok := log.LineTime(line); ok {
+		if !f.since.IsZero() && t.Before(f.since) {
+			return false
+		}
+	} else if !f.since.IsZero() && !previous {
+		return false
+	}
+	return f.grep == nil || f.grep.MatchString(line)
+}
+
+// errNotInControl is returned if the running tbm doesn't run the service
+var errNotInControl = errors.New("service isn't running in tbm")
+
+// control writes the matching lines the running tbm keeps in memory for a service
+func (f logFilter) control(w io.Writer, client *proc.ControlClient, service string, environment string, follow bool) error {
+	services, err := client.Services()
+	if err != nil {
+		return err
+	}
+	found := false
+	for _, status := range services {
+		if status.Service == service && (environment == "" || status.Environment == environment) {
+			found = true
+		}
+	}
+	if !found {
+		return errNotInControl
+	}
+	previous := false
+	return client.Logs(service, environment, follow, func(line string) error {
+		previous = f.match(line, previous)
+		if !previous {
+			return nil
+		}
+		_, err := io.WriteString(w, line)
+		return err
+	})
+}
+
+// copy writes the matching complete lines of a file from offset on and returns the offset after the last of them
+func (f logFilter) copy(w io.Writer, path string, offset int64) (int64, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return offset, err
+	}
+	defer file.Close()
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return offset, err
+	}
+	r := bufio.NewReader(file)
+	previous := false
+	for {
+		line, err := r.ReadString('\n')
+		if errors.Is(err, io.EOF) {
+			// Partial lines are picked up again once they are complete
+			return offset, nil
+		}
+		if err != nil {
+			return offset, err
+		}
+		offset += int64(len(line))
+		previous = f.match(line, previous)
+		if previous {
+			if _, err := io.WriteString(w, line); err != nil {
+				return offset, err
+			}
+		}
+	}
+}
+
+// follow polls the log file for new lines. Once it's rotated, the new file is read from the start.
+func (f logFilter) follow(w io.Writer, path string, offset int64) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	for {
+		time.Sleep(250 * time.Millisecond)
+		current, err := os.Stat(path)
+		if errors.Is(err, os.ErrNotExist) {
+			// The file is being rotated right now
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		if !os.SameFile(info, current) || current.Size() < offset {
+			info, offset = current, 0
+		}
+		if current.Size() == offset {
+			continue
+		}
+		if offset, err = f.copy(w, path, offset); err != nil {
+			return err
+		}
+	}
+}
+
+func init() {
+	rootCmd.AddCommand(logsCmd)
+	logsCmd.Flags().String("env", "", "Environment of the service, needed if the service has logs in several environments")
+	logsCmd.Flags().BoolP("follow", "f", false, "Keep printing new lines of the service")
+	logsCmd.Flags().String("since", "", "Only show lines since a duration ago (10m) or a date (2006-01-02 15:04)")
+	logsCmd.Flags().String("grep", "", "Only show lines matching this regular expression")
+	logsCmd.Flags().Bool("files", false, "Read the log files even if tbm is running the service")
+}
@@ -0,0 +1,105 @@
+package cmd
+
+import (
+	"bufio"
+	"errors"
+	"github.com/dewey/tbm/secret"
+	"github.com/mattn/go-isatty"
+	"github.com/spf13/cobra"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// secretsCmd represents the secrets command
+var secretsCmd = &cobra.Command{
+	Use:   "secrets",
+	Short: "Manage secrets in the local encrypted store",
+	Long: `Manage the secrets in the local encrypted store. Variables use them with "from: store" and the name of the secret,
+so the configuration file never contains the values. The store is encrypted with a key in the user's config directory.`,
+}
+
+var secretsSetCmd = &cobra.Command{
+	Use:   "set <name>",
+	Short: "Store a secret, the value is read from the terminal or standard input",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := secret.DefaultStore()
+		if err != nil {
+			return err
+		}
+		value, err := readSecret(cmd, bufio.NewReader(cmd.InOrStdin()), "Value of "+args[0]+": ")
+		if err != nil {
+			return err
+		}
+		if value == "" {
+			return errors.New("the value of a secret can't be empty")
+		}
+		return store.Set(args[0], value)
+	},
+}
+
+var secretsListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List the names of all stored secrets",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := secret.DefaultStore()
+		if err != nil {
+			return err
+		}
+		names, err := store.Names()
+		if err != nil {
+			return err
+		}
+		for _, name := range names {
+			cmd.Println(name)
+		}
+		return nil
+	},
+}
+
+var secretsRemoveCmd = &cobra.Command{
+	Use:   "remove <name>",
+	Short: "Remove a secret from the store",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		store, err := secret.DefaultStore()
+		if err != nil {
+			return err
+		}
+		return store.Delete(args[0])
+	},
+}
+
+// readSecret reads a line from the reader of standard input. On a terminal the user is prompted and the input isn't shown.
+func readSecret(cmd *cobra.Command, reader *bufio.Reader, prompt string) (string, error) {
+	if isatty.IsTerminal(os.Stdin.Fd()) {
+		cmd.Print(prompt)
+		if err := stty("-echo"); err == nil {
+			defer func() {
+				//nolint
+				stty("echo")
+				cmd.Println()
+			}()
+		}
+	}
+	line, err := reader.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// stty changes a setting of the terminal on standard input
+func stty(setting string) error {
+	c := exec.Command("stty", setting)
+	c.Stdin = os.Stdin
+	return c.Run()
+}
+
+func init() {
+	rootCmd.AddCommand(secretsCmd)
+	secretsCmd.AddCommand(secretsSetCmd, secretsListCmd, secretsRemoveCmd)
+}
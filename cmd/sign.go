@@ -0,0 +1,261 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dewey/tbm/remote"
+	"github.com/spf13/cobra"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// configKeygenCmd represents the config keygen command
+var configKeygenCmd = &cobra.Command{
+	Use:   "keygen <private key file>",
+	Short: "Create a key pair to sign configuration files with",
+	Long: `Create a key pair to sign the configuration files of a team with. The private key is written to the file, keep it
+secret. The public key is printed, everyone who uses the configuration files adds it with tbm config trust.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		public, private, err := remote.GenerateKey()
+		if err != nil {
+			return err
+		}
+		f, err := os.OpenFile(args[0], os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if errors.Is(err, os.ErrExist) {
+			return fmt.Errorf("%s already exists", args[0])
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintln(f, private); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+		cmd.Printf("Wrote the private key to %s. The public key is:\n", args[0])
+		_, err = fmt.Fprintln(cmd.OutOrStdout(), public)
+		return err
+	},
+}
+
+// configSignCmd represents the config sign command
+var configSignCmd = &cobra.Command{
+	Use:   "sign <file>",
+	Short: "Sign a configuration file",
+	Long: `Sign a configuration file with a private key of tbm config keygen. The signature is added as a comment block at
+the end of the file, or written to <file>.sig with --detached. Publish the .sig file next to the configuration file.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		keyPath, err := cmd.Flags().GetString("key")
+		if err != nil {
+			return err
+		}
+		detached, err := cmd.Flags().GetBool("detached")
+		if err != nil {
+			return err
+		}
+		b, err := os.ReadFile(keyPath)
+		if err != nil {
+			return err
+		}
+		key, err := remote.ParsePrivateKey(string(b))
+		if err != nil {
+			return err
+		}
+		content, err := os.ReadFile(args[0])
+		if err != nil {
+			return err
+		}
+		if detached {
+			if err := os.WriteFile(args[0]+".sig", []byte(remote.Sign(content, key)+"\n"), 0o644); err != nil {
+				return err
+			}
+			cmd.Printf("Wrote the signature to %s.sig\n", args[0])
+			return nil
+		}
+		signed, err := remote.Embed(content, key)
+		if err != nil {
+			return err
+		}
+		if err := os.WriteFile(args[0], signed, 0o644); err != nil {
+			return err
+		}
+		cmd.Printf("Signed %s\n", args[0])
+		return nil
+	},
+}
+
+// configTrustCmd represents the config trust command
+var configTrustCmd = &cobra.Command{
+	Use:   "trust [public key] [name]",
+	Short: "Trust a key remote configuration files are signed with, or list the trusted keys",
+	Long: `Trust a public key of tbm config keygen. Once a key is trusted, remote configuration files are only accepted
+if they are signed with one of the trusted keys. Without arguments, the trusted keys are listed.`,
+	Args: cobra.RangeArgs(0, 2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := remote.TrustedKeysPath()
+		if err != nil {
+			return err
+		}
+		if len(args) == 0 {
+			keys, err := remote.LoadTrustedKeys(path)
+			if err != nil {
+				return err
+			}
+			if len(keys) == 0 {
+				cmd.Println("No keys are trusted, remote configuration files don't have to be signed")
+			}
+			for _, key := range keys {
+				fmt.Fprintln(cmd.OutOrStdout(), strings.TrimSpace(remote.FormatPublicKey(key.Key)+" "+key.Name))
+			}
+			return nil
+		}
+		key, err := remote.ParsePublicKey(args[0])
+		if err != nil {
+			return err
+		}
+		trusted := remote.TrustedKey{Key: key}
+		if len(args) > 1 {
+			trusted.Name = args[1]
+		}
+		if err := remote.AddTrustedKey(path, trusted); err != nil {
+			return err
+		}
+		cmd.Printf("Trusted the key, remote configuration files now have to be signed with one of the keys in %s\n", path)
+		return nil
+	},
+}
+
+// fetchConfig fetches a remote configuration file with the fetch flags, see fetchVerified
+func fetchConfig(cmd *cobra.Command, rawURL string, dir string) (verifiedConfig, error) {
+	fetcher, err := newFetcher(cmd)
+	if err != nil {
+		return verifiedConfig{}, err
+	}
+	pin, err := cmd.Flags().GetString("sha256")
+	if err != nil {
+		return verifiedConfig{}, err
+	}
+	verified, err := fetchVerified(cmd, fetcher, rawURL, dir, pin)
+	if err != nil {
+		return verifiedConfig{}, err
+	}
+	switch {
+	case verified.key.Name != "":
+		cmd.Printf("Verified the signature of %s\n", verified.key.Name)
+	case verified.signed && verified.key.Key == nil:
+		cmd.Println("The configuration file is signed, but no keys are trusted. Add the key of your team with tbm config trust to verify it.")
+	}
+	return verified, nil
+}
+
+// verifiedConfig is a fetched remote configuration file without its signature block
+type verifiedConfig struct {
+	body []byte
+	// signed is set if the file has a signature
+	signed bool
+	// key is the trusted key the file is signed with, it's empty if no keys are trusted
+	key remote.TrustedKey
+	// offline is set if the remote couldn't be reached and the body is the cached copy
+	offline bool
+}
+
+// fetchVerified fetches a remote configuration file and verifies it. The cached copy in dir is used if the remote
+// can't be reached. The checksum is compared with the pin and, if any keys are trusted, the embedded or detached
+// signature has to match one of them.
+func fetchVerified(cmd *cobra.Command, fetcher *remote.Fetcher, rawURL string, dir string, pin string) (verifiedConfig, error) {
+	cached, err := fetcher.FetchCached(rawURL, filepath.Join(dir, "cache.yaml"))
+	if err != nil {
+		return verifiedConfig{}, err
+	}
+	if cached.Offline != nil {
+		cmd.Printf("Using the cached copy of %s, last checked %s: %s\n", rawURL, formatAge(cached.Age(time.Now())), cached.Offline)
+	}
+	if pin != "" {
+		if err := remote.CheckSHA256(cached.Content, pin); err != nil {
+			return verifiedConfig{}, fmt.Errorf("rejected %s: %w", rawURL, err)
+		}
+	}
+	body, signature, err := remote.SplitSignature(cached.Content)
+	if err != nil {
+		return verifiedConfig{}, fmt.Errorf("rejected %s: %w", rawURL, err)
+	}
+	path, err := remote.TrustedKeysPath()
+	if err != nil {
+		return verifiedConfig{}, err
+	}
+	keys, err := remote.LoadTrustedKeys(path)
+	if err != nil {
+		return verifiedConfig{}, err
+	}
+	verified := verifiedConfig{body: body, signed: signature != "", offline: cached.Offline != nil}
+	if len(keys) == 0 {
+		// Detached signatures are only fetched if they're checked
+		return verified, nil
+	}
+	if signature == "" {
+		if signature, err = fetchDetachedSignature(fetcher, rawURL, dir, cached.Offline != nil); err != nil {
+			return verifiedConfig{}, err
+		}
+		verified.signed = signature != ""
+	}
+	if verified.key, err = remote.Verify(body, signature, keys); err != nil {
+		return verifiedConfig{}, fmt.Errorf("rejected %s: %w", rawURL, err)
+	}
+	return verified, nil
+}
+
+// fetchDetachedSignature fetches the signature published as <url>.sig, it's empty if there is none. Private buckets
+// answer 403 for files that don't exist, so it's treated like 404. If the remote can't be reached and no signature was
+// cached, the configuration file is treated as unsigned.
+func fetchDetachedSignature(fetcher *remote.Fetcher, rawURL string, dir string, offline bool) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	u.Path += ".sig"
+	cached, err := fetcher.FetchCached(u.String(), filepath.Join(dir, "cache.yaml.sig"))
+	var statusErr *remote.StatusError
+	if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusForbidden) {
+		return "", nil
+	}
+	if err != nil && offline && statusErr == nil {
+		// The configuration file is the cached copy, the signature would be cached as well if there was one
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(cached.Content)), nil
+}
+
+// formatAge shows how long ago something happened
+func formatAge(d time.Duration) string {
+	switch {
+	case d < time.Minute:
+		return "just now"
+	case d < time.Hour:
+		return fmt.Sprintf("%dm ago", int(d.Minutes()))
+	case d < 48*time.Hour:
+		return fmt.Sprintf("%dh ago", int(d.Hours()))
+	default:
+		return fmt.Sprintf("%d days ago", int(d.Hours()/24))
+	}
+}
+
+func init() {
+	configCmd.AddCommand(configKeygenCmd)
+	configCmd.AddCommand(configSignCmd)
+	configCmd.AddCommand(configTrustCmd)
+	configSignCmd.Flags().String("key", "", "File with the private key of tbm config keygen")
+	//nolint
+	configSignCmd.MarkFlagRequired("key")
+	configSignCmd.Flags().Bool("detached", false, "Write the signature to <file>.sig instead of adding it to the file")
+}
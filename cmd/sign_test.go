@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"bytes"
+	"errors"
+	"github.com/dewey/tbm/remote"
+	"github.com/spf13/cobra"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFetchVerified_Offline(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	const config = "services:\n    db:\n      command: proxy\n"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/c.yaml" {
+			http.NotFound(w, r)
+			return
+		}
+		w.Write([]byte(config))
+	}))
+	dir := t.TempDir()
+	fetcher := remote.NewFetcher(remote.Options{NetrcPath: filepath.Join(dir, "missing")})
+	cmd := &cobra.Command{}
+	cmd.SetErr(&bytes.Buffer{})
+
+	if _, err := fetchVerified(cmd, fetcher, server.URL+"/c.yaml", dir, ""); err != nil {
+		t.Fatalf("fetchVerified() error = %v", err)
+	}
+	server.Close()
+	verified, err := fetchVerified(cmd, fetcher, server.URL+"/c.yaml", dir, "")
+	if err != nil {
+		t.Fatalf("fetchVerified() with the server down error = %v", err)
+	}
+	if string(verified.body) != config || verified.signed {
+		t.Errorf("fetchVerified() with the server down = %q (signed: %v), want the cached unsigned copy", verified.body, verified.signed)
+	}
+}
+
+func TestFetchVerified_DetachedSignature(t *testing.T) {
+	t.Setenv("XDG_CONFIG_HOME", t.TempDir())
+	const config = "services:\n    db:\n      command: proxy\n"
+	public, private, err := remote.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := remote.ParsePrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var (
+		signatures atomic.Int32
+		published  atomic.Bool
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/c.yaml":
+			w.Write([]byte(config))
+		case "/c.yaml.sig":
+			signatures.Add(1)
+			if !published.Load() {
+				// Private buckets don't tell missing files apart
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(remote.Sign([]byte(config), key)))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	dir := t.TempDir()
+	fetcher := remote.NewFetcher(remote.Options{NetrcPath: filepath.Join(dir, "missing")})
+	cmd := &cobra.Command{}
+	cmd.SetErr(&bytes.Buffer{})
+
+	if _, err := fetchVerified(cmd, fetcher, server.URL+"/c.yaml", dir, ""); err != nil {
+		t.Fatalf("fetchVerified() without trusted keys error = %v", err)
+	}
+	if got := signatures.Load(); got != 0 {
+		t.Errorf("fetchVerified() without trusted keys fetched the signature %d times, want 0", got)
+	}
+
+	path, err := remote.TrustedKeysPath()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := remote.ParsePublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := remote.AddTrustedKey(path, remote.TrustedKey{Key: parsed, Name: "platform"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fetchVerified(cmd, fetcher, server.URL+"/c.yaml", dir, ""); !errors.Is(err, remote.ErrUnsigned) {
+		t.Errorf("fetchVerified() with a forbidden signature error = %v, want %v", err, remote.ErrUnsigned)
+	}
+	published.Store(true)
+	verified, err := fetchVerified(cmd, fetcher, server.URL+"/c.yaml", dir, "")
+	if err != nil {
+		t.Fatalf("fetchVerified() with a detached signature error = %v", err)
+	}
+	if verified.key.Name != "platform" {
+		t.Errorf("fetchVerified() key = %q, want platform", verified.key.Name)
+	}
+}
@@ -1,14 +1,22 @@
 package cmd
 
 import (
+	"bufio"
 	"context"
 	"errors"
+	"fmt"
 	"github.com/dewey/tbm/config"
+	"github.com/dewey/tbm/history"
+	"github.com/dewey/tbm/log"
 	"github.com/dewey/tbm/proc"
+	"github.com/dewey/tbm/secret"
+	"github.com/mattn/go-isatty"
 	"github.com/spf13/cobra"
 	"gopkg.in/yaml.v3"
+	"io"
 	"os"
 	"path"
+	"path/filepath"
 	"strings"
 )
 
@@ -24,42 +32,72 @@ be started`,
 		_, cancel := context.WithCancel(ctx)
 		defer cancel()
 
-		// If user provided a custom config file location, we read it from there. Otherwise, we are using the default
-		// location in the user's home directory.
-		configFlag := cmd.Flag("config")
-		var configFilePath string
-		if configFlag.Value.String() != configFlag.DefValue {
-			// Replace tilde in user provided string, otherwise we can't resolve it
-			hd, err := os.UserHomeDir()
-			if err != nil {
-				return err
-			}
-			configFilePath = strings.Replace(configFlag.Value.String(), "~", hd, -1)
-		} else {
-			hd, err := os.UserHomeDir()
+		configuration, _, err := loadConfig(cmd)
+		if err != nil {
+			return err
+		}
+
+		offline, err := cmd.PersistentFlags().GetBool("offline")
+		if err != nil {
+			return errors.New("couldn't parse offline flag")
+		}
+		if !offline {
+			configFilePath, err := configPath(cmd)
 			if err != nil {
 				return err
 			}
-			configFilePath = path.Join(hd, ".tbm.yaml")
+			checkRemoteConfig(cmd, configFilePath)
 		}
-		// Check if configuration file already exists, otherwise we direct the user to use `tbm init`
-		if _, err := os.Stat(configFilePath); errors.Is(err, os.ErrNotExist) {
-			return errors.New("configuration file doesn't exist. Use `tbm init` to create one or use --config to pass a path")
+
+		if err := configuration.Validate(); err != nil {
+			return fmt.Errorf("invalid configuration file: %w", err)
 		}
-		b, err := os.ReadFile(configFilePath)
+
+		// Answers typed ahead stay buffered in the reader, so all questions read from the same one
+		reader := bufio.NewReader(cmd.InOrStdin())
+		yes, err := cmd.PersistentFlags().GetBool("yes")
 		if err != nil {
-			return err
+			return errors.New("couldn't parse yes flag")
+		}
+		if !yes {
+			for _, environment := range configuration.ProtectedEnvironments() {
+				if err := confirmEnvironment(cmd, reader, environment); err != nil {
+					return err
+				}
+			}
+		}
+
+		ask, err := cmd.PersistentFlags().GetBool("ask")
+		if err != nil {
+			return errors.New("couldn't parse ask flag")
 		}
-		var configuration config.Configuration
-		if err := yaml.Unmarshal(b, &configuration); err != nil {
+		if err := answerPrompts(cmd, reader, configuration, ask); err != nil {
 			return err
 		}
 
-		if !configuration.Valid() {
-			return errors.New("invalid configuration file, make sure ports are unique across services")
+		logFormat, err := cmd.PersistentFlags().GetString("log-format")
+		if err != nil {
+			return errors.New("couldn't parse log-format flag")
+		}
+		format, err := log.ParseFormat(logFormat)
+		if err != nil {
+			return err
 		}
 
 		svc := proc.NewServicesService(configuration)
+		svc.Sink = log.NewSink(format, os.Stdout)
+		stateDir, err := config.StateDir()
+		if err != nil {
+			return err
+		}
+		svc.History = history.New(stateDir)
+		svc.LogDir = filepath.Join(stateDir, "logs")
+		svc.SocketPath = proc.SocketPath(stateDir)
+		store, err := secret.DefaultStore()
+		if err != nil {
+			return err
+		}
+		svc.Resolver = secret.NewResolver(store)
 		err = svc.ReadProcfile(configuration)
 		if err != nil {
 			return err
@@ -85,6 +123,138 @@ be started`,
 	},
 }
 
+// configPath returns the path of the configuration file. If user provided a custom config file location, we read it
+// from there. Otherwise, we are using the default location in the user's home directory.
+func configPath(cmd *cobra.Command) (string, error) {
+	hd, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	configFlag := cmd.Flag("config")
+	if configFlag != nil && configFlag.Value.String() != configFlag.DefValue {
+		// Replace tilde in user provided string, otherwise we can't resolve it
+		return strings.Replace(configFlag.Value.String(), "~", hd, -1), nil
+	}
+	return path.Join(hd, ".tbm.yaml"), nil
+}
+
+// loadConfig reads the configuration file with the local file merged on top of it
+func loadConfig(cmd *cobra.Command) (config.Configuration, []config.Notice, error) {
+	configFilePath, err := configPath(cmd)
+	if err != nil {
+		return config.Configuration{}, nil, err
+	}
+	// Check if configuration file already exists, otherwise we direct the user to use `tbm init`
+	if _, err := os.Stat(configFilePath); errors.Is(err, os.ErrNotExist) {
+		return config.Configuration{}, nil, errors.New("configuration file doesn't exist. Use `tbm init` to create one or use --config to pass a path")
+	}
+	fetcher, err := newIncludeFetcher(cmd)
+	if err != nil {
+		return config.Configuration{}, nil, err
+	}
+	return config.Load(configFilePath, fetcher)
+}
+
+// confirmEnvironment asks the user to type the name of a protected environment before services in it are started
+func confirmEnvironment(cmd *cobra.Command, reader *bufio.Reader, environment string) error {
+	if !isatty.IsTerminal(os.Stdin.Fd()) && !isatty.IsCygwinTerminal(os.Stdin.Fd()) {
+		return fmt.Errorf("environment %s is protected and needs a confirmation, use --yes when running non-interactively", environment)
+	}
+	cmd.Printf("Environment %s is protected. Type the name of the environment to start its services: ", environment)
+	answer, err := reader.ReadString('\n')
+	if err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	if strings.TrimSpace(answer) != environment {
+		return fmt.Errorf("confirmation for protected environment %s failed, not starting any services", environment)
+	}
+	return nil
+}
+
+// answerPrompts asks the user for the values of prompted variables. Answers remembered in the local file are used
+// unless ask is set, new answers to variables with remember are stored there.
+func answerPrompts(cmd *cobra.Command, reader *bufio.Reader, configuration config.Configuration, ask bool) error {
+	prompts := configuration.Prompts()
+	if len(prompts) == 0 {
+		return nil
+	}
+	configFilePath, err := configPath(cmd)
+	if err != nil {
+		return err
+	}
+	answers, err := config.RememberedAnswers(config.LocalPath(configFilePath), prompts)
+	if err != nil {
+		return err
+	}
+	terminal := isatty.IsTerminal(os.Stdin.Fd()) || isatty.IsCygwinTerminal(os.Stdin.Fd())
+	var remembered []config.Prompt
+	for _, prompt := range prompts {
+		if answer, ok := answers[prompt.Name]; ok && !ask && prompt.Variable.CheckAnswer(answer) == nil {
+			configuration.Answer(prompt.Name, answer)
+			continue
+		}
+		if !terminal {
+			return fmt.Errorf("variable %s of %s needs an answer to %q, run tbm start in a terminal to answer it", prompt.Name, strings.Join(prompt.Services, ", "), prompt.Variable.Prompt)
+		}
+		answer, err := askPrompt(cmd, reader, prompt)
+		if err != nil {
+			return err
+		}
+		configuration.Answer(prompt.Name, answer)
+		if prompt.Variable.Remember {
+			answers[prompt.Name] = answer
+			remembered = append(remembered, prompt)
+		}
+	}
+	if len(remembered) == 0 {
+		return nil
+	}
+	_, err = editConfigFile(cmd, true, nil, func(node *yaml.Node) error {
+		for _, prompt := range remembered {
+			if err := config.RememberAnswer(node, prompt, answers[prompt.Name]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return err
+}
+
+// askPrompt asks for the value of a variable until the answer is valid. The value of the variable is the default.
+func askPrompt(cmd *cobra.Command, reader *bufio.Reader, prompt config.Prompt) (string, error) {
+	question := prompt.Variable.Prompt
+	if prompt.Variable.Value != "" && !prompt.Variable.Secret {
+		question = fmt.Sprintf("%s [%s]", question, prompt.Variable.Value)
+	}
+	question += ": "
+	for attempt := 0; attempt < 3; attempt++ {
+		var answer string
+		var err error
+		if prompt.Variable.Secret {
+			answer, err = readSecret(cmd, reader, question)
+		} else {
+			cmd.Print(question)
+			answer, err = reader.ReadString('\n')
+			answer = strings.TrimSpace(answer)
+		}
+		if err != nil && !errors.Is(err, io.EOF) {
+			return "", err
+		}
+		if answer == "" {
+			answer = prompt.Variable.Value
+		}
+		checkErr := prompt.Variable.CheckAnswer(answer)
+		if checkErr == nil {
+			return answer, nil
+		}
+		cmd.Printf("Invalid answer: %s\n", checkErr)
+		if errors.Is(err, io.EOF) {
+			break
+		}
+	}
+	return "", fmt.Errorf("no valid answer for variable %s", prompt.Name)
+}
+
 func init() {
 	rootCmd.AddCommand(startCmd)
 
@@ -99,4 +269,8 @@ func init() {
 	startCmd.PersistentFlags().String("config", configFilePath, "Location of the configuration file.")
 	startCmd.PersistentFlags().Bool("exit-on-stop", true, "Exit tbm if all services stop")
 	startCmd.PersistentFlags().Bool("exit-on-error", true, "Exit tbm if one of the services encounters an error")
+	startCmd.PersistentFlags().String("log-format", string(log.FormatText), "Output format of the logs, text or json")
+	startCmd.PersistentFlags().Bool("yes", false, "Start services in protected environments without asking for a confirmation")
+	startCmd.PersistentFlags().Bool("ask", false, "Ask for the values of prompted variables again, even if the answers are remembered")
+	startCmd.PersistentFlags().Bool("offline", false, "Don't check the remote configuration file for updates or fetch included URLs, their cached copies are used")
 }
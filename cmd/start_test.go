@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"bufio"
+	"bytes"
+	"github.com/dewey/tbm/config"
+	"github.com/spf13/cobra"
+	"strings"
+	"testing"
+)
+
+func TestAskPrompt_TypedAhead(t *testing.T) {
+	cmd := &cobra.Command{}
+	cmd.SetErr(&bytes.Buffer{})
+	reader := bufio.NewReader(strings.NewReader("dewey\nbastion-2\n"))
+	prompts := []config.Prompt{
+		{Name: "user", Variable: config.Variable{Prompt: "Your LDAP user"}},
+		{Name: "host", Variable: config.Variable{Prompt: "Bastion host"}},
+	}
+	var got []string
+	for _, prompt := range prompts {
+		answer, err := askPrompt(cmd, reader, prompt)
+		if err != nil {
+			t.Fatalf("askPrompt() of %s error = %v", prompt.Name, err)
+		}
+		got = append(got, answer)
+	}
+	if want := "dewey bastion-2"; strings.Join(got, " ") != want {
+		t.Errorf("askPrompt() answers = %q, want %q", got, want)
+	}
+}
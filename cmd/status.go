@@ -0,0 +1,65 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"github.com/dewey/tbm/config"
+	"github.com/dewey/tbm/proc"
+	"github.com/spf13/cobra"
+	"text/tabwriter"
+	"time"
+)
+
+// statusCmd represents the status command
+var statusCmd = &cobra.Command{
+	Use:   "status",
+	Short: "Show the services of a running tbm",
+	Long: `Show the services of a tbm running in another terminal with their process id, and the time left until services
+with a max lifetime are stopped.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		environment, err := cmd.Flags().GetString("env")
+		if err != nil {
+			return err
+		}
+		stateDir, err := config.StateDir()
+		if err != nil {
+			return err
+		}
+		services, err := proc.NewControlClient(proc.SocketPath(stateDir)).Services()
+		if errors.Is(err, proc.ErrNotRunning) {
+			return errors.New("tbm isn't running, start it with `tbm start`")
+		}
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SERVICE\tENVIRONMENT\tSTATUS\tPID\tSTOPS IN")
+		for _, status := range services {
+			if environment != "" && status.Environment != environment {
+				continue
+			}
+			state, pid := "stopped", "-"
+			if status.Running {
+				state, pid = "running", fmt.Sprint(status.PID)
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", status.Service, status.Environment, state, pid, formatLifetime(status))
+		}
+		return w.Flush()
+	},
+}
+
+// formatLifetime shows the time left until a running service reaches its max lifetime
+func formatLifetime(status proc.ServiceStatus) string {
+	if status.ExpiresAt == nil {
+		return "-"
+	}
+	remaining := time.Duration(status.RemainingSeconds) * time.Second
+	return fmt.Sprintf("%s (at %s)", remaining, status.ExpiresAt.Local().Format("15:04:05"))
+}
+
+func init() {
+	rootCmd.AddCommand(statusCmd)
+	statusCmd.Flags().String("env", "", "Only show services of this environment")
+}
@@ -0,0 +1,100 @@
+package cmd
+
+import (
+	"fmt"
+	"github.com/dewey/tbm/config"
+	"github.com/spf13/cobra"
+	"os"
+	"path"
+	"text/tabwriter"
+)
+
+// validateCmd represents the validate command
+var validateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "Check the configuration file and show what the local file changes",
+	Long: `Check the configuration file, with the local file (like ~/.tbm.local.yaml) merged on top of it. Every value
+of the shared configuration that the local file shadows and every included service the configuration file overrides
+is listed, conflicts are values of another type. Services are
+listed with their status and command, values of secret variables are redacted.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		configFilePath, err := configPath(cmd)
+		if err != nil {
+			return err
+		}
+		configuration, notices, err := loadConfig(cmd)
+		if err != nil {
+			return err
+		}
+		problems := 0
+
+		cmd.Printf("Configuration file: %s\n", configFilePath)
+		localPath := config.LocalPath(configFilePath)
+		if _, err := os.Stat(localPath); err != nil {
+			cmd.Printf("Local file: %s (doesn't exist)\n", localPath)
+		} else {
+			cmd.Printf("Local file: %s\n", localPath)
+		}
+		for _, include := range configuration.Include {
+			cmd.Printf("Included: %s\n", include)
+		}
+		if len(notices) > 0 {
+			cmd.Println()
+			cmd.Println("Changes of included and shared values:")
+			for _, notice := range notices {
+				if notice.Conflict {
+					problems++
+					cmd.Printf("  conflict: %s\n", notice)
+				} else {
+					cmd.Printf("  %s\n", notice)
+				}
+			}
+		}
+
+		cmd.Println()
+		for _, problem := range configuration.Problems() {
+			problems++
+			cmd.Printf("Invalid configuration: %s\n\n", problem)
+		}
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "SERVICE\tENVIRONMENT\tSTATUS\tCOMMAND")
+		for _, key := range configuration.ServiceNames() {
+			service := configuration.Services[key]
+			status := "enabled"
+			if err := service.Validate(); err != nil {
+				status = "invalid: " + err.Error()
+				if service.Enable {
+					problems++
+				}
+			} else if !service.Enable {
+				status = "disabled"
+			}
+			command, err := service.RedactedCommand()
+			if err != nil {
+				command = service.Command
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", key, service.Environment, status, command)
+		}
+		if err := w.Flush(); err != nil {
+			return err
+		}
+
+		if problems > 0 {
+			return fmt.Errorf("found %d problem(s) in the configuration", problems)
+		}
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(validateCmd)
+
+	var configFilePath string
+	hd, err := os.UserHomeDir()
+	if err == nil {
+		configFilePath = path.Join(hd, ".tbm.yaml")
+	} else {
+		configFilePath = "~/.tbm.yaml"
+	}
+	validateCmd.Flags().String("config", configFilePath, "Location of the configuration file.")
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"regexp"
+	"strings"
+)
+
+// composeService is the part of a service of a docker compose file that tbm imports
+type composeService struct {
+	Ports     []composePort `yaml:"ports"`
+	DependsOn yaml.Node     `yaml:"depends_on"`
+}
+
+// composePort is a port of a compose service, either in the short syntax like "127.0.0.1:8080:80/tcp" or as mapping
+type composePort struct {
+	HostIP    string `yaml:"host_ip"`
+	Published string `yaml:"published"`
+	Target    string `yaml:"target"`
+	Protocol  string `yaml:"protocol"`
+}
+
+// UnmarshalYAML parses the short syntax of ports
+func (p *composePort) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind != yaml.ScalarNode {
+		type plain composePort
+		return value.Decode((*plain)(p))
+	}
+	spec := value.Value
+	spec, p.Protocol, _ = strings.Cut(spec, "/")
+	index := strings.LastIndex(spec, ":")
+	if index < 0 {
+		// Only the port of the container, docker picks a random port on the host
+		p.Target = spec
+		return nil
+	}
+	p.Target = spec[index+1:]
+	p.Published = spec[:index]
+	if index := strings.LastIndex(p.Published, ":"); index >= 0 {
+		p.HostIP, p.Published = p.Published[:index], p.Published[index+1:]
+	}
+	return nil
+}
+
+// shellSafe matches strings that don't have to be quoted in a shell
+var shellSafe = regexp.MustCompile(`^[A-Za-z0-9_./@:=+-]+$`)
+
+// ParseCompose converts the services of a docker compose file into a configuration. Every service is run with docker
+// compose run from the file at path, its published ports become variables: the first one is port, the others are
+// named by the port in the container like port_443. The returned notes describe what couldn't be imported.
+func ParseCompose(b []byte, path string, defaults ImportDefaults) (Configuration, []string, error) {
+	configuration := Configuration{Version: CurrentVersion, Services: make(map[string]Service)}
+	var document yaml.Node
+	if err := yaml.Unmarshal(b, &document); err != nil {
+		return configuration, nil, fmt.Errorf("invalid compose file: %w", err)
+	}
+	if len(document.Content) == 0 || mappingIndex(document.Content[0], "services") < 0 {
+		return configuration, nil, errors.New("the compose file doesn't have any services")
+	}
+	root := document.Content[0]
+	services := resolveAlias(root.Content[mappingIndex(root, "services")+1])
+	file := path
+	if !shellSafe.MatchString(file) {
+		file = "'" + strings.ReplaceAll(file, "'", `'\''`) + "'"
+	}
+
+	var notes []string
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		name := services.Content[i].Value
+		var compose composeService
+		if err := services.Content[i+1].Decode(&compose); err != nil {
+			return configuration, nil, fmt.Errorf("invalid service %s: %w", name, err)
+		}
+		service := Service{Environment: defaults.Environment, Enable: defaults.Enable}
+		command := []string{"docker", "compose", "-f", file, "run", "--rm", "--no-deps"}
+		for _, port := range compose.Ports {
+			if port.Published == "" {
+				notes = append(notes, fmt.Sprintf("%s: port %s isn't published on a fixed port and is skipped", name, port.Target))
+				continue
+			}
+			if strings.Contains(port.Published, "-") || strings.Contains(port.Target, "-") {
+				notes = append(notes, fmt.Sprintf("%s: the port range %s:%s is skipped", name, port.Published, port.Target))
+				continue
+			}
+			variable := "port"
+			if len(service.Variables) > 0 {
+				variable = "port_" + port.Target
+				if port.Protocol != "" && port.Protocol != "tcp" {
+					variable += "_" + port.Protocol
+				}
+			}
+			mapping := fmt.Sprintf("{{.%s}}:%s", variable, port.Target)
+			if port.HostIP != "" {
+				mapping = port.HostIP + ":" + mapping
+			}
+			if port.Protocol != "" {
+				mapping += "/" + port.Protocol
+			}
+			command = append(command, "-p", mapping)
+			service.Variables = append(service.Variables, map[string]string{variable: port.Published})
+		}
+		service.Command = strings.Join(append(command, name), " ")
+		service.DependsOn = composeDependencies(&compose.DependsOn)
+		configuration.Services[name] = service
+		configuration.order = append(configuration.order, name)
+	}
+	if len(configuration.Services) == 0 {
+		return configuration, nil, errors.New("the compose file doesn't have any services")
+	}
+	return configuration, notes, nil
+}
+
+// composeDependencies returns the names of the services of depends_on, it's either a list or a mapping with conditions
+func composeDependencies(node *yaml.Node) []string {
+	var names []string
+	switch node.Kind {
+	case yaml.SequenceNode:
+		for _, item := range node.Content {
+			names = append(names, item.Value)
+		}
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			names = append(names, node.Content[i].Value)
+		}
+	}
+	return names
+}
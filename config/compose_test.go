@@ -0,0 +1,55 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseCompose(t *testing.T) {
+	in := `services:
+  db:
+    image: postgres
+    ports:
+      - "127.0.0.1:5432:5432"
+  api:
+    build: .
+    command: ./api --listen :80
+    ports:
+      - 8080:80
+      - target: 443
+        published: 8443
+      - "53:53/udp"
+      - "9000"
+    depends_on: [db]
+`
+	c, notes, err := ParseCompose([]byte(in), "/src/my app/docker-compose.yml", ImportDefaults{Environment: "dev", Enable: true})
+	if err != nil {
+		t.Fatalf("ParseCompose() error = %v", err)
+	}
+	if got, want := c.ServiceNames(), []string{"db", "api"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ParseCompose() services = %v, want %v", got, want)
+	}
+	if len(notes) != 1 {
+		t.Errorf("ParseCompose() notes = %v, want one for the unpublished port", notes)
+	}
+	api := c.Services["api"]
+	wantCommand := "docker compose -f '/src/my app/docker-compose.yml' run --rm --no-deps -p {{.port}}:80 -p {{.port_443}}:443 -p {{.port_53_udp}}:53/udp api"
+	if api.Command != wantCommand {
+		t.Errorf("command = %q, want %q", api.Command, wantCommand)
+	}
+	wantVariables := []map[string]string{{"port": "8080"}, {"port_443": "8443"}, {"port_53_udp": "53"}}
+	if !reflect.DeepEqual(api.Variables, wantVariables) {
+		t.Errorf("variables = %v, want %v", api.Variables, wantVariables)
+	}
+	if !reflect.DeepEqual(api.DependsOn, []string{"db"}) {
+		t.Errorf("depends_on = %v, want [db]", api.DependsOn)
+	}
+	if _, port := c.Services["db"].VariableValue("port"); port != "5432" {
+		t.Errorf("port of db = %q, want 5432", port)
+	}
+	for name, service := range c.Services {
+		if err := service.Validate(); err != nil {
+			t.Errorf("imported service %s is invalid: %v", name, err)
+		}
+	}
+}
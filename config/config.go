@@ -2,10 +2,19 @@ package config
 
 import (
 	"errors"
+	"fmt"
+	"github.com/dewey/tbm/log"
+	"github.com/dewey/tbm/redact"
+	"gopkg.in/yaml.v3"
 	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 	"text/template/parse"
+	"time"
 )
 
 // Service is a single configuration option for a service we want to run
@@ -16,12 +25,298 @@ type Service struct {
 	// Variables are string mappings, the key can be used as $KEY in the "Command" string. It will be interpolated when
 	// it is used to spawn the proc
 	Variables []map[string]string `yaml:"variables"`
+	// Definitions are the variables that were defined with settings like secret, by name. Their values are in
+	// Variables as well.
+	Definitions map[string]Variable `yaml:"-"`
+	// Type is either "daemon" (the default) for long-running services or "oneshot" for tasks that run to completion
+	Type string `yaml:"type,omitempty"`
+	// Schedule runs the command periodically. It's either an interval ("50m") or a cron expression ("*/5 * * * *").
+	Schedule string `yaml:"schedule,omitempty"`
+	// DependsOn is a list of service names that need to be ready before this service is started. One-shot tasks are
+	// ready once they finished successfully.
+	DependsOn []string `yaml:"depends_on,omitempty"`
+	// MaxLifetime stops the service automatically after it ran for the given duration. If not set, the default of the
+	// environment is used.
+	MaxLifetime time.Duration `yaml:"max_lifetime,omitempty"`
+	// RestartEvery restarts the service periodically, for proxies whose credentials expire
+	RestartEvery time.Duration `yaml:"restart_every,omitempty"`
+	// OnOutput rules react to lines printed by the service
+	OnOutput []OutputRule `yaml:"on_output,omitempty"`
+	// Hooks are commands run before and after the service is started or stopped
+	Hooks ServiceHooks `yaml:"hooks,omitempty"`
+	// QuietStdout hides the standard output of the service, only lines written to standard error are shown
+	QuietStdout bool `yaml:"quiet_stdout,omitempty"`
+	// Color of the lines of the service in the terminal, a name like "cyan", a number up to 255 or "#ff8700". Without
+	// it, the color is picked by the name of the service.
+	Color string `yaml:"color,omitempty"`
 }
 
+// DefaultHookTimeout is used for hooks that don't set a timeout
+const DefaultHookTimeout = time.Minute
+
+// Hook is a command run at a point in the lifecycle of a service or tbm. In the configuration file it's either just
+// the command, or a mapping with command and timeout.
+type Hook struct {
+	Command string `yaml:"command"`
+	// Timeout is how long the command may run before it's killed, DefaultHookTimeout if not set
+	Timeout time.Duration `yaml:"timeout,omitempty"`
+}
+
+// UnmarshalYAML allows hooks to be written as a plain command
+func (h *Hook) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		h.Command = value.Value
+		return nil
+	}
+	type plain Hook
+	return value.Decode((*plain)(h))
+}
+
+// TimeoutOrDefault returns the timeout of the hook, or DefaultHookTimeout if it isn't set
+func (h Hook) TimeoutOrDefault() time.Duration {
+	if h.Timeout > 0 {
+		return h.Timeout
+	}
+	return DefaultHookTimeout
+}
+
+// ServiceHooks are run by tbm around the start and stop of a service. A failing pre_start hook keeps the service from
+// starting.
+type ServiceHooks struct {
+	PreStart  *Hook `yaml:"pre_start,omitempty"`
+	PostStart *Hook `yaml:"post_start,omitempty"`
+	PreStop   *Hook `yaml:"pre_stop,omitempty"`
+	PostStop  *Hook `yaml:"post_stop,omitempty"`
+}
+
+// GlobalHooks are run before any service is started and after all services stopped. A failing before hook keeps tbm
+// from starting any service.
+type GlobalHooks struct {
+	Before *Hook `yaml:"before,omitempty"`
+	After  *Hook `yaml:"after,omitempty"`
+}
+
+// OutputRule triggers an action every time a line of output of a service matches a regular expression
+type OutputRule struct {
+	// Match is a regular expression that is matched against every line
+	Match string `yaml:"match"`
+	// Action is one of "ready", "restart", "fail", "run" or "severity"
+	Action string `yaml:"action"`
+	// Run is the command executed by the "run" action
+	Run string `yaml:"run,omitempty"`
+	// Severity is used by the "severity" action to highlight the line, either "warning" or "error"
+	Severity string `yaml:"severity,omitempty"`
+}
+
+const (
+	// ActionReady marks the service as ready, dependent services are only started once a line matched
+	ActionReady = "ready"
+	// ActionRestart restarts the service
+	ActionRestart = "restart"
+	// ActionFail stops tbm with an error
+	ActionFail = "fail"
+	// ActionRun runs a command, for example to send a notification
+	ActionRun = "run"
+	// ActionSeverity highlights the line with the given severity
+	ActionSeverity = "severity"
+)
+
+const (
+	// SeverityWarning highlights a line as a warning
+	SeverityWarning = "warning"
+	// SeverityError highlights a line as an error
+	SeverityError = "error"
+)
+
+// Validate checks that the regular expression compiles and the action has the settings it needs
+func (r OutputRule) Validate() error {
+	if _, err := regexp.Compile(r.Match); err != nil {
+		return fmt.Errorf("invalid on_output match %q: %w", r.Match, err)
+	}
+	switch r.Action {
+	case ActionReady, ActionRestart, ActionFail:
+	case ActionRun:
+		if r.Run == "" {
+			return fmt.Errorf("on_output action %s for %q needs a command to run", r.Action, r.Match)
+		}
+	case ActionSeverity:
+		if r.Severity != SeverityWarning && r.Severity != SeverityError {
+			return fmt.Errorf("on_output severity for %q has to be %s or %s", r.Match, SeverityWarning, SeverityError)
+		}
+	default:
+		return fmt.Errorf("unknown on_output action %q", r.Action)
+	}
+	return nil
+}
+
+// Environment holds settings that apply to all services of an environment
+type Environment struct {
+	// MaxLifetime is the default maximum lifetime of services in this environment
+	MaxLifetime time.Duration `yaml:"max_lifetime,omitempty"`
+	// Protected environments need an interactive confirmation before services are started and are highlighted in the
+	// log output
+	Protected bool `yaml:"protected,omitempty"`
+}
+
+const (
+	// TypeDaemon is a long-running service that is kept running until tbm stops
+	TypeDaemon = "daemon"
+	// TypeOneshot is a task that runs to completion, like a login check or a migration
+	TypeOneshot = "oneshot"
+)
+
 // Configuration holds a configuration, the key of the map is the name of the configuration. This is a string defined by
 // the user to differentiate the various services started.
 type Configuration struct {
+	// Version is the version of the file format, see CurrentVersion
+	Version  int `yaml:"version,omitempty"`
 	Services map[string]Service
+	// Environments contains settings shared by services of an environment, the key is the environment name
+	Environments map[string]Environment `yaml:"environments,omitempty"`
+	// Hooks are run before the first service is started and after all services stopped
+	Hooks GlobalHooks `yaml:"hooks,omitempty"`
+	// Logs configures the log files written for every service
+	Logs Logs `yaml:"logs,omitempty"`
+	// Include lists other configuration files whose services are added
+	Include []Include `yaml:"include,omitempty"`
+	// order are the names of the services in the order they are declared in
+	order []string
+}
+
+// UnmarshalYAML keeps the order the services are declared in
+func (s *Configuration) UnmarshalYAML(value *yaml.Node) error {
+	type plain Configuration
+	if err := value.Decode((*plain)(s)); err != nil {
+		return err
+	}
+	s.order = serviceNames(value)
+	return nil
+}
+
+// ServiceNames returns the names of the services in the order they are declared in. With includes, the services of
+// the included files come first. Services that weren't read from a file are sorted by name at the end.
+func (s Configuration) ServiceNames() []string {
+	names := make([]string, 0, len(s.Services))
+	seen := make(map[string]bool, len(s.Services))
+	for _, name := range s.order {
+		if _, ok := s.Services[name]; ok && !seen[name] {
+			names = append(names, name)
+			seen[name] = true
+		}
+	}
+	var rest []string
+	for name := range s.Services {
+		if !seen[name] {
+			rest = append(rest, name)
+		}
+	}
+	sort.Strings(rest)
+	return append(names, rest...)
+}
+
+// Defaults for the log files and the in-memory buffer of services
+const (
+	DefaultLogMaxSize  ByteSize = 10 * 1024 * 1024
+	DefaultLogMaxFiles          = 5
+	DefaultLogBuffer            = 1000
+)
+
+// Logs configures the log files tbm writes for every service next to the terminal output, and the recent lines it
+// keeps in memory
+type Logs struct {
+	// Disable turns off log files
+	Disable bool `yaml:"disable,omitempty"`
+	// MaxSize is the size a log file is rotated at, like "10MB"
+	MaxSize ByteSize `yaml:"max_size,omitempty"`
+	// MaxFiles is the number of rotated files kept per service
+	MaxFiles int `yaml:"max_files,omitempty"`
+	// Buffer is the number of recent lines kept in memory per service, they are shown by tbm logs and tbm attach
+	Buffer int `yaml:"buffer,omitempty"`
+}
+
+// MaxSizeOrDefault returns the max size of a log file, or DefaultLogMaxSize if it isn't set
+func (l Logs) MaxSizeOrDefault() ByteSize {
+	if l.MaxSize > 0 {
+		return l.MaxSize
+	}
+	return DefaultLogMaxSize
+}
+
+// MaxFilesOrDefault returns the number of rotated log files kept, or DefaultLogMaxFiles if it isn't set
+func (l Logs) MaxFilesOrDefault() int {
+	if l.MaxFiles > 0 {
+		return l.MaxFiles
+	}
+	return DefaultLogMaxFiles
+}
+
+// BufferOrDefault returns the number of lines kept in memory per service, or DefaultLogBuffer if it isn't set
+func (l Logs) BufferOrDefault() int {
+	if l.Buffer > 0 {
+		return l.Buffer
+	}
+	return DefaultLogBuffer
+}
+
+// ByteSize is a size in bytes. In the configuration file it's either a number of bytes or a number with a unit like
+// "512KB" or "10MB".
+type ByteSize int64
+
+var byteSizeUnits = []struct {
+	suffix string
+	size   ByteSize
+}{
+	{suffix: "GB", size: 1024 * 1024 * 1024},
+	{suffix: "MB", size: 1024 * 1024},
+	{suffix: "KB", size: 1024},
+	{suffix: "B", size: 1},
+}
+
+// ParseByteSize parses a size like "10MB"
+func ParseByteSize(s string) (ByteSize, error) {
+	value := strings.ToUpper(strings.TrimSpace(s))
+	unit := ByteSize(1)
+	for _, u := range byteSizeUnits {
+		if strings.HasSuffix(value, u.suffix) {
+			value = strings.TrimSpace(strings.TrimSuffix(value, u.suffix))
+			unit = u.size
+			break
+		}
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q, use a number of bytes or a unit like 10MB", s)
+	}
+	return ByteSize(n) * unit, nil
+}
+
+// UnmarshalYAML parses sizes with units
+func (b *ByteSize) UnmarshalYAML(value *yaml.Node) error {
+	size, err := ParseByteSize(value.Value)
+	if err != nil {
+		return err
+	}
+	*b = size
+	return nil
+}
+
+// MarshalYAML writes sizes with the largest unit that fits exactly
+func (b ByteSize) MarshalYAML() (interface{}, error) {
+	for _, u := range byteSizeUnits {
+		if b >= u.size && b%u.size == 0 {
+			return fmt.Sprintf("%d%s", b/u.size, u.suffix), nil
+		}
+	}
+	return int64(b), nil
+}
+
+// MaxLifetime returns the maximum lifetime of a service, falling back to the default of its environment. Zero means
+// the service runs until tbm is stopped.
+func (s Configuration) MaxLifetime(service Service) time.Duration {
+	if service.MaxLifetime > 0 {
+		return service.MaxLifetime
+	}
+	return s.Environments[service.Environment].MaxLifetime
 }
 
 func (s Service) VariableValue(name string) (bool, string) {
@@ -36,6 +331,58 @@ func (s Service) VariableValue(name string) (bool, string) {
 	return false, ""
 }
 
+// Protected returns true if the given environment is flagged as protected
+func (s Configuration) Protected(environment string) bool {
+	return s.Environments[environment].Protected
+}
+
+// ProtectedEnvironments returns the sorted names of protected environments that have at least one valid service
+func (s Configuration) ProtectedEnvironments() []string {
+	m := make(map[string]struct{})
+	for _, service := range s.Services {
+		if service.Valid() && s.Protected(service.Environment) {
+			m[service.Environment] = struct{}{}
+		}
+	}
+	var environments []string
+	for environment := range m {
+		environments = append(environments, environment)
+	}
+	sort.Strings(environments)
+	return environments
+}
+
+// IsOneshot returns true if the service is a task that runs to completion
+func (s Service) IsOneshot() bool {
+	return s.Type == TypeOneshot
+}
+
+// ParsedSchedule returns the parsed schedule of a service, or nil if the service isn't scheduled
+func (s Service) ParsedSchedule() (*Schedule, error) {
+	if s.Schedule == "" {
+		return nil, nil
+	}
+	return ParseSchedule(s.Schedule)
+}
+
+// StateDir returns the directory tbm keeps its state in, like the history of sessions. It's created if it doesn't
+// exist yet. $XDG_STATE_HOME is respected, the default is ~/.local/state/tbm.
+func StateDir() (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		hd, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(hd, ".local", "state")
+	}
+	dir = filepath.Join(dir, "tbm")
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return "", err
+	}
+	return dir, nil
+}
+
 // Create checks if a given config file already exists, if not it creates one
 func Create(path string, b []byte) (bool, error) {
 	// Create config file if it doesn't exist yet
@@ -55,42 +402,111 @@ func Create(path string, b []byte) (bool, error) {
 
 // Valid validates a full configuration. This is mainly aiming at making sure we have unique port configurations.
 func (s Configuration) Valid() bool {
-	m := make(map[string]struct{})
-	for _, service := range s.Services {
-		for _, variable := range service.Variables {
-			_, ok := m[variable["port"]]
-			if ok {
-				return false
+	return s.Validate() == nil
+}
+
+// Problem is something that keeps the configuration from being valid, like a port used by two services
+type Problem struct {
+	// Path is the setting with the problem, like services.db.variables.port
+	Path    string
+	Message string
+}
+
+func (p Problem) Error() string {
+	return p.Message
+}
+
+// Validate returns the first problem of the configuration, see Problems
+func (s Configuration) Validate() error {
+	if problems := s.Problems(); len(problems) > 0 {
+		return problems[0]
+	}
+	return nil
+}
+
+// Problems returns everything that keeps the configuration from being valid: ports have to be unique across services.
+// The problems are sorted by the services they belong to.
+func (s Configuration) Problems() []Problem {
+	var problems []Problem
+	m := make(map[string]string)
+	var keys []string
+	for key := range s.Services {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		for _, variable := range s.Services[key].Variables {
+			port, ok := variable["port"]
+			if !ok {
+				continue
+			}
+			if other, ok := m[port]; ok {
+				problems = append(problems, Problem{
+					Path:    fmt.Sprintf("services.%s.variables.port", key),
+					Message: fmt.Sprintf("port %s is used by %s and %s, ports have to be unique across services", port, other, key),
+				})
+				continue
 			}
-			m[variable["port"]] = struct{}{}
+			m[port] = key
 		}
 	}
-	return true
+	return problems
 }
 
 // InterpolatedCommand is replacing the variable placeholders in a string with the variable value
 func (s Service) InterpolatedCommand() (string, error) {
-	var finalCommand string
-	tmpl, err := template.New("command").Parse(s.Command)
+	return s.Interpolate(s.Command)
+}
+
+// Interpolate replaces the variable placeholders in any command, like the one of a hook, with the variable values
+func (s Service) Interpolate(command string) (string, error) {
+	return s.interpolate(command, func(name string, value string) string {
+		return value
+	})
+}
+
+// Redacted is shown instead of the value of secret variables
+const Redacted = redact.Placeholder
+
+// RedactedCommand is the interpolated command with the values of secret variables and of variables that look like
+// credentials replaced. It's safe to be shown or stored.
+func (s Service) RedactedCommand() (string, error) {
+	return s.interpolate(s.Command, func(name string, value string) string {
+		if s.Secret(name) || SensitiveName(name) {
+			return Redacted
+		}
+		return value
+	})
+}
+
+// SensitiveName returns true if the name of a variable suggests that it contains a credential
+func SensitiveName(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range []string{"password", "passwd", "secret", "token", "credential", "apikey", "api_key"} {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// interpolate replaces all variable placeholders in the command with the value returned by the given function
+func (s Service) interpolate(command string, value func(name string, value string) string) (string, error) {
+	tmpl, err := template.New("command").Parse(command)
 	if err != nil {
 		return "", err
 	}
 
 	// Replace variables in command string if variables exist, otherwise we just return the original command
-	variables := ListTemplateFields(tmpl)
-	if len(s.Variables) > 0 {
-		for _, val := range s.Variables {
-			for _, val := range val {
-				for _, variable := range variables {
-					if strings.Contains(s.Command, variable) {
-						finalCommand = strings.Replace(s.Command, variable, val, -1)
-					}
-				}
-			}
+	for _, field := range ListTemplateFields(tmpl) {
+		name := strings.ToLower(strings.Trim(field, "{}."))
+		exists, val := s.VariableValue(name)
+		if !exists {
+			continue
 		}
-		return finalCommand, nil
+		command = strings.Replace(command, field, value(name, val), -1)
 	}
-	return s.Command, nil
+	return command, nil
 }
 
 // Valid returns true if a service is enabled and has all the required values set
@@ -99,36 +515,71 @@ func (s Service) Valid() bool {
 	if !s.Enable {
 		return false
 	}
+	return s.Validate() == nil
+}
+
+// Validate returns why a service can't be started, no matter if it's enabled
+func (s Service) Validate() error {
+	if err := s.validSettings(); err != nil {
+		return err
+	}
 
 	vars, err := extractVariables(s.Command)
 	if err != nil {
-		return false
+		return fmt.Errorf("invalid command: %w", err)
 	}
 
 	// Fail early if different counts
 	if len(vars) != len(s.Variables) {
-		return false
+		return fmt.Errorf("the command uses %d variables, but %d are defined", len(vars), len(s.Variables))
 	}
 
 	vm := make(map[string]struct{})
 	for _, v := range vars {
-		if _, ok := vm[v]; !ok {
-			vm[v] = struct{}{}
-		}
+		vm[v] = struct{}{}
 	}
 	for _, variable := range s.Variables {
 		for key := range variable {
-			if val, ok := vm[key]; ok {
-				if vm[key] != val {
-					return false
-				}
-			} else {
-				return false
+			if _, ok := vm[key]; !ok {
+				return fmt.Errorf("variable %s isn't used in the command", key)
 			}
 		}
 	}
 
-	return true
+	return nil
+}
+
+// validSettings makes sure the service type is known, the schedule can be parsed and the output rules and variables
+// are valid
+func (s Service) validSettings() error {
+	switch s.Type {
+	case "", TypeDaemon, TypeOneshot:
+	default:
+		return fmt.Errorf("unknown service type %q", s.Type)
+	}
+	if _, err := s.ParsedSchedule(); err != nil {
+		return err
+	}
+	for _, rule := range s.OnOutput {
+		if err := rule.Validate(); err != nil {
+			return err
+		}
+	}
+	if s.Color != "" {
+		if _, err := log.ParseColor(s.Color); err != nil {
+			return err
+		}
+	}
+	for name, variable := range s.Definitions {
+		if err := variable.Validate(); err != nil {
+			return fmt.Errorf("invalid variable %s: %w", name, err)
+		}
+		// The port is needed before the service is started, to check it's unique
+		if name == "port" && variable.From != "" {
+			return errors.New("the port can't come from a provider")
+		}
+	}
+	return nil
 }
 
 // extractVariables parses a command template and returns the unique Go template variables that were used
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+)
+
+// Kinds of changes to a service
+const (
+	ChangeAdded   = "added"
+	ChangeRemoved = "removed"
+	ChangeChanged = "changed"
+)
+
+// ServiceChange is how a service differs between two configurations
+type ServiceChange struct {
+	Service string
+	Kind    string
+	// Details describe the changed settings of a changed service, like its command or port
+	Details []string
+}
+
+// Diff returns the changed services between two configurations, sorted by name. Commands are redacted.
+func Diff(old Configuration, new Configuration) []ServiceChange {
+	names := make(map[string]struct{})
+	for name := range old.Services {
+		names[name] = struct{}{}
+	}
+	for name := range new.Services {
+		names[name] = struct{}{}
+	}
+	var sorted []string
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+
+	var changes []ServiceChange
+	for _, name := range sorted {
+		o, inOld := old.Services[name]
+		n, inNew := new.Services[name]
+		switch {
+		case !inOld:
+			changes = append(changes, ServiceChange{Service: name, Kind: ChangeAdded, Details: []string{describe(n)}})
+		case !inNew:
+			changes = append(changes, ServiceChange{Service: name, Kind: ChangeRemoved, Details: []string{describe(o)}})
+		default:
+			if details := diffService(o, n); len(details) > 0 {
+				changes = append(changes, ServiceChange{Service: name, Kind: ChangeChanged, Details: details})
+			}
+		}
+	}
+	return changes
+}
+
+// describe summarizes a service for an added or removed service
+func describe(s Service) string {
+	command, err := s.RedactedCommand()
+	if err != nil {
+		command = s.Command
+	}
+	_, port := s.VariableValue("port")
+	return fmt.Sprintf("environment %q, port %q, enabled %t: %s", s.Environment, port, s.Enable, command)
+}
+
+// diffService describes the settings that changed between two versions of a service
+func diffService(o Service, n Service) []string {
+	var details []string
+	oldCommand, err := o.RedactedCommand()
+	if err != nil {
+		oldCommand = o.Command
+	}
+	newCommand, err := n.RedactedCommand()
+	if err != nil {
+		newCommand = n.Command
+	}
+	if oldCommand != newCommand {
+		details = append(details, fmt.Sprintf("command: %s -> %s", oldCommand, newCommand))
+	}
+	_, oldPort := o.VariableValue("port")
+	_, newPort := n.VariableValue("port")
+	if oldPort != newPort {
+		details = append(details, fmt.Sprintf("port: %q -> %q", oldPort, newPort))
+	}
+	if o.Environment != n.Environment {
+		details = append(details, fmt.Sprintf("environment: %q -> %q", o.Environment, n.Environment))
+	}
+	if o.Enable != n.Enable {
+		details = append(details, fmt.Sprintf("enable: %t -> %t", o.Enable, n.Enable))
+	}
+	if len(details) == 0 && !reflect.DeepEqual(o, n) {
+		details = append(details, "other settings like variables or hooks changed")
+	}
+	return details
+}
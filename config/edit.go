@@ -0,0 +1,129 @@
+package config
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"strings"
+)
+
+// SetServiceValue sets a setting of a service in a configuration file node, the key is a path like enable or
+// variables.port. The value is parsed as YAML, so "true" is a boolean and "[a, b]" a list. The node has to be migrated
+// to the current version.
+func SetServiceValue(node *yaml.Node, service string, key string, value string) error {
+	path := strings.Split(key, ".")
+	if !serviceKey(path[0]) {
+		return fmt.Errorf("unknown setting %s of services", path[0])
+	}
+	for _, name := range path {
+		if name == "" {
+			return fmt.Errorf("invalid setting %s", key)
+		}
+	}
+	parsed, err := ParseValue(value)
+	if err != nil {
+		return fmt.Errorf("invalid value of %s: %w", key, err)
+	}
+	return SetValue(node, append([]string{"services", service}, path...), parsed)
+}
+
+// ParseValue parses a value given on the command line as YAML, an empty value is an empty string
+func ParseValue(value string) (*yaml.Node, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(value), &document); err != nil {
+		return nil, err
+	}
+	if len(document.Content) == 0 {
+		return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Style: yaml.DoubleQuotedStyle}, nil
+	}
+	return document.Content[0], nil
+}
+
+// SetValue sets the value at a path of keys in a configuration file node, like services.db.enable. Missing mappings
+// on the way are added and the comments of a replaced value are kept. Aliased mappings on the way are copied, so the
+// other users of the anchor don't change.
+func SetValue(node *yaml.Node, path []string, value *yaml.Node) error {
+	mapping, err := editableMapping(node, path[:len(path)-1], true)
+	if err != nil {
+		return err
+	}
+	key := path[len(path)-1]
+	index := mappingIndex(mapping, key)
+	if index < 0 {
+		mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, value)
+		return nil
+	}
+	previous := mapping.Content[index+1]
+	if value.HeadComment == "" && value.LineComment == "" && value.FootComment == "" {
+		value.HeadComment, value.LineComment, value.FootComment = previous.HeadComment, previous.LineComment, previous.FootComment
+	}
+	mapping.Content[index+1] = value
+	return nil
+}
+
+// DeleteValue removes the key at a path of keys from a configuration file node, like services.db
+func DeleteValue(node *yaml.Node, path []string) error {
+	mapping, err := editableMapping(node, path[:len(path)-1], false)
+	if err != nil {
+		return err
+	}
+	index := mappingIndex(mapping, path[len(path)-1])
+	if index < 0 {
+		return fmt.Errorf("%s doesn't exist", strings.Join(path, "."))
+	}
+	mapping.Content = append(mapping.Content[:index:index], mapping.Content[index+2:]...)
+	return nil
+}
+
+// editableMapping returns the mapping at a path of keys. Aliases are replaced by copies, and once a mapping was copied
+// the mappings below it are copied as well because they are still shared with the anchor.
+func editableMapping(node *yaml.Node, path []string, create bool) (*yaml.Node, error) {
+	mapping := node
+	copied := false
+	for i, key := range path {
+		if mapping.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s isn't a mapping", strings.Join(path[:i], "."))
+		}
+		index := mappingIndex(mapping, key)
+		if index < 0 {
+			if !create {
+				return nil, fmt.Errorf("%s doesn't exist", strings.Join(path[:i+1], "."))
+			}
+			child := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			mapping.Content = append(mapping.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: key}, child)
+			mapping = child
+			continue
+		}
+		child := mapping.Content[index+1]
+		if child.Kind == yaml.AliasNode || copied {
+			duplicate := *resolveAlias(child)
+			duplicate.Content = append([]*yaml.Node(nil), duplicate.Content...)
+			duplicate.Anchor = ""
+			mapping.Content[index+1] = &duplicate
+			child = &duplicate
+			copied = true
+		}
+		mapping = child
+	}
+	if mapping.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("%s isn't a mapping", strings.Join(path, "."))
+	}
+	return mapping, nil
+}
+
+// serviceKey returns true if the key is a setting of services
+func serviceKey(key string) bool {
+	t := reflect.TypeOf(Service{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name == key && name != "-" {
+			return true
+		}
+	}
+	return false
+}
+
+// Placeholders returns the names of the variables a command uses, in the order they are used
+func Placeholders(command string) ([]string, error) {
+	return extractVariables(command)
+}
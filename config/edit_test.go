@@ -0,0 +1,82 @@
+package config
+
+import (
+	"gopkg.in/yaml.v3"
+	"strings"
+	"testing"
+)
+
+const editFile = `version: 2
+services:
+    db: &db
+        command: proxy -p {{.port}} # the proxy
+        enable: false
+        variables:
+            port: 10001 # keep this
+    replica: *db
+`
+
+func TestSetServiceValue(t *testing.T) {
+	tests := []struct {
+		name    string
+		service string
+		key     string
+		value   string
+		want    []string
+		wantErr bool
+	}{
+		{name: "replace", service: "db", key: "enable", value: "true", want: []string{"db: &db\n        command: proxy -p {{.port}} # the proxy\n        enable: true\n"}},
+		{name: "keep comment", service: "db", key: "variables.port", value: "10002", want: []string{"port: 10002 # keep this\n", "replica: *db\n"}},
+		{name: "add", service: "db", key: "hooks.pre_start", value: "gcloud auth login", want: []string{"    hooks:\n            pre_start: gcloud auth login\n"}},
+		{name: "list", service: "db", key: "depends_on", value: "[vpn]", want: []string{"depends_on: [vpn]\n"}},
+		{name: "new service", service: "cache", key: "command", value: "redis", want: []string{"    cache:\n        command: redis\n"}},
+		{name: "alias is copied", service: "replica", key: "variables.port", value: "10002", want: []string{"port: 10001 # keep this\n    replica:\n", "port: 10002 # keep this\n"}},
+		{name: "unknown setting", service: "db", key: "enabel", value: "true", wantErr: true},
+		{name: "invalid value", service: "db", key: "depends_on", value: "[vpn", wantErr: true},
+		{name: "not a mapping", service: "db", key: "command.x", value: "1", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var document yaml.Node
+			if err := yaml.Unmarshal([]byte(editFile), &document); err != nil {
+				t.Fatal(err)
+			}
+			err := SetServiceValue(document.Content[0], tt.service, tt.key, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("SetServiceValue() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			out := encodeEdited(t, &document)
+			for _, want := range tt.want {
+				if !strings.Contains(out, want) {
+					t.Errorf("changed file doesn't contain %q:\n%s", want, out)
+				}
+			}
+		})
+	}
+}
+
+func TestDeleteValue(t *testing.T) {
+	var document yaml.Node
+	if err := yaml.Unmarshal([]byte(editFile), &document); err != nil {
+		t.Fatal(err)
+	}
+	if err := DeleteValue(document.Content[0], []string{"services", "replica"}); err != nil {
+		t.Fatalf("DeleteValue() error = %v", err)
+	}
+	if out := encodeEdited(t, &document); strings.Contains(out, "replica") {
+		t.Errorf("deleted service is still there:\n%s", out)
+	}
+	if err := DeleteValue(document.Content[0], []string{"services", "replica"}); err == nil {
+		t.Errorf("DeleteValue() of a missing service didn't fail")
+	}
+}
+
+func encodeEdited(t *testing.T, document *yaml.Node) string {
+	var b strings.Builder
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(4)
+	if err := enc.Encode(document); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
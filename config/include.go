@@ -0,0 +1,222 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Include is another configuration file whose services are added to the configuration
+type Include struct {
+	// Path is a file, a glob like ~/.tbm.d/*.yaml or a file:// or https:// URL. In a git repository, it's the path of
+	// the file in the repository.
+	Path string `yaml:"path"`
+	// Git is the path of a local git repository the file is read from
+	Git string `yaml:"git,omitempty"`
+	// Ref is the branch, tag or commit of the repository, it defaults to HEAD
+	Ref string `yaml:"ref,omitempty"`
+}
+
+// UnmarshalYAML allows includes to be written as a plain path
+func (i *Include) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		i.Path = value.Value
+		return nil
+	}
+	type plain Include
+	return value.Decode((*plain)(i))
+}
+
+// MarshalYAML writes includes that are only a path as a plain path
+func (i Include) MarshalYAML() (interface{}, error) {
+	if i.Git == "" && i.Ref == "" {
+		return i.Path, nil
+	}
+	type plain Include
+	return plain(i), nil
+}
+
+func (i Include) String() string {
+	if i.Git == "" {
+		return i.Path
+	}
+	ref := i.Ref
+	if ref == "" {
+		ref = "HEAD"
+	}
+	return fmt.Sprintf("%s at %s in %s", i.Path, ref, i.Git)
+}
+
+// URLFetcher downloads included files of https URLs
+type URLFetcher interface {
+	Fetch(url string) ([]byte, error)
+}
+
+// ErrUnreachable is returned by URL fetchers if an included URL can't be reached and there is no cached copy. The
+// include is skipped then, only its services are missing.
+var ErrUnreachable = errors.New("it can't be reached and there is no cached copy")
+
+// fragment is an included file
+type fragment struct {
+	source string
+	node   *yaml.Node
+}
+
+// includes returns the includes of a configuration file node
+func includes(node *yaml.Node) ([]Include, error) {
+	index := mappingIndex(node, "include")
+	if index < 0 {
+		return nil, nil
+	}
+	var list []Include
+	if err := node.Content[index+1].Decode(&list); err != nil {
+		return nil, fmt.Errorf("invalid include: %w", err)
+	}
+	return list, nil
+}
+
+// readIncludes reads the files of the includes in their order, the files of a glob are sorted by their path. Relative
+// paths are relative to dir. URLs that can't be reached without a cached copy are skipped with a notice.
+func readIncludes(list []Include, dir string, fetcher URLFetcher) ([]fragment, []Notice, error) {
+	var fragments []fragment
+	var notices []Notice
+	for _, include := range list {
+		switch {
+		case include.Path == "":
+			return nil, nil, errors.New("include without path")
+		case include.Git != "":
+			b, err := readGitFile(expandPath(strings.TrimPrefix(include.Git, "file://"), dir), include.Ref, include.Path)
+			if err != nil {
+				return nil, nil, err
+			}
+			if fragments, err = appendFragment(fragments, include.String(), b); err != nil {
+				return nil, nil, err
+			}
+		case strings.HasPrefix(include.Path, "https://") || strings.HasPrefix(include.Path, "http://"):
+			if fetcher == nil {
+				return nil, nil, fmt.Errorf("can't include %s, URLs aren't supported here", include.Path)
+			}
+			b, err := fetcher.Fetch(include.Path)
+			if errors.Is(err, ErrUnreachable) {
+				notices = append(notices, Notice{Path: "include", Message: fmt.Sprintf("skipped %s, its services are missing: %s", include.Path, err)})
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			if fragments, err = appendFragment(fragments, include.Path, b); err != nil {
+				return nil, nil, err
+			}
+		default:
+			pattern := expandPath(strings.TrimPrefix(include.Path, "file://"), dir)
+			matches, err := filepath.Glob(pattern)
+			if err != nil {
+				return nil, nil, fmt.Errorf("invalid include %s: %w", include.Path, err)
+			}
+			if len(matches) == 0 && !strings.ContainsAny(pattern, "*?[") {
+				return nil, nil, fmt.Errorf("included file %s doesn't exist", include.Path)
+			}
+			sort.Strings(matches)
+			for _, match := range matches {
+				b, err := os.ReadFile(match)
+				if err != nil {
+					return nil, nil, err
+				}
+				if fragments, err = appendFragment(fragments, match, b); err != nil {
+					return nil, nil, err
+				}
+			}
+		}
+	}
+	return fragments, notices, nil
+}
+
+// mergeIncludes merges the fragments into one mapping. A service may only be defined by one fragment.
+func mergeIncludes(fragments []fragment) (*yaml.Node, map[string]string, error) {
+	combined := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	sources := make(map[string]string)
+	for _, f := range fragments {
+		for _, name := range serviceNames(f.node) {
+			if source, ok := sources[name]; ok {
+				return nil, nil, fmt.Errorf("service %s is defined in both %s and %s", name, source, f.source)
+			}
+			sources[name] = f.source
+		}
+		Merge(combined, f.node)
+	}
+	return combined, sources, nil
+}
+
+// serviceNames returns the names of the services of a configuration file node
+func serviceNames(node *yaml.Node) []string {
+	index := mappingIndex(node, "services")
+	if index < 0 {
+		return nil
+	}
+	services := resolveAlias(node.Content[index+1])
+	var names []string
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		names = append(names, services.Content[i].Value)
+	}
+	return names
+}
+
+// appendFragment parses an included file and appends it to the fragments
+func appendFragment(fragments []fragment, source string, b []byte) ([]fragment, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(b, &document); err != nil {
+		return nil, fmt.Errorf("invalid included file %s: %w", source, err)
+	}
+	node := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if len(document.Content) > 0 {
+		node = document.Content[0]
+	}
+	if node.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("included file %s isn't a configuration file", source)
+	}
+	if mappingIndex(node, "include") >= 0 {
+		return nil, fmt.Errorf("included file %s can't include other files", source)
+	}
+	if _, err := Migrate(node); err != nil {
+		return nil, fmt.Errorf("included file %s: %w", source, err)
+	}
+	return append(fragments, fragment{source: source, node: node}), nil
+}
+
+// readGitFile returns a file of a local git repository at a ref
+func readGitFile(repository string, ref string, path string) ([]byte, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	if strings.HasPrefix(ref, "-") {
+		return nil, fmt.Errorf("invalid ref %s", ref)
+	}
+	//nolint:gosec
+	out, err := exec.Command("git", "-C", repository, "show", ref+":"+filepath.ToSlash(path)).Output()
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return nil, fmt.Errorf("can't read %s at %s in %s: %s", path, ref, repository, strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return nil, err
+	}
+	return out, nil
+}
+
+// expandPath replaces a leading ~ with the home directory and makes relative paths relative to dir
+func expandPath(path string, dir string) string {
+	if path == "~" || strings.HasPrefix(path, "~/") {
+		if hd, err := os.UserHomeDir(); err == nil {
+			path = filepath.Join(hd, strings.TrimPrefix(path, "~"))
+		}
+	}
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(dir, path)
+	}
+	return path
+}
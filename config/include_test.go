@@ -0,0 +1,196 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+)
+
+// mapFetcher serves included URLs from memory
+type mapFetcher map[string]string
+
+func (f mapFetcher) Fetch(url string) ([]byte, error) {
+	content, ok := f[url]
+	if !ok {
+		return nil, fmt.Errorf("fetching %s failed: 404 Not Found", url)
+	}
+	return []byte(content), nil
+}
+
+// unreachableFetcher fails like a fetcher without network and cached copies
+type unreachableFetcher struct{}
+
+func (unreachableFetcher) Fetch(url string) ([]byte, error) {
+	return nil, fmt.Errorf("%w: fetching %s failed: no route to host", ErrUnreachable, url)
+}
+
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	t.Helper()
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func service(name string, port int) string {
+	return fmt.Sprintf("services:\n    %s:\n      command: proxy {{.port}}\n      environment: prod\n      variables:\n        - port: %d\n", name, port)
+}
+
+func TestLoad_Include(t *testing.T) {
+	dir := t.TempDir()
+	repository := filepath.Join(dir, "repository")
+	writeFiles(t, repository, map[string]string{"tbm/search.yaml": service("search", 10005)})
+	for _, args := range [][]string{
+		{"init", "-q"},
+		{"add", "."},
+		{"-c", "user.name=tbm", "-c", "user.email=tbm@example.com", "commit", "-q", "-m", "Add search"},
+		{"tag", "v1"},
+	} {
+		if out, err := exec.Command("git", append([]string{"-C", repository}, args...)...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	// Changes after the tag must not be included
+	writeFiles(t, repository, map[string]string{"tbm/search.yaml": service("search", 10006)})
+
+	writeFiles(t, dir, map[string]string{
+		"tbm.d/20-payments.yaml": service("payments", 10002),
+		"tbm.d/10-data.yaml":     service("db", 10001) + "      enable: false\n",
+		"tbm.yaml": `include:
+    - tbm.d/*.yaml
+    - https://example.com/cache.yaml
+    - git: repository
+      ref: v1
+      path: tbm/search.yaml
+services:
+    db:
+      enable: true
+`,
+		"tbm.local.yaml": "include:\n    - file://" + filepath.Join(dir, "private.yaml") + "\n",
+		"private.yaml":   service("scratch", 10004),
+	})
+	fetcher := mapFetcher{"https://example.com/cache.yaml": service("cache", 10003)}
+
+	c, notices, err := Load(filepath.Join(dir, "tbm.yaml"), fetcher)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	ports := make(map[string]string)
+	for name, s := range c.Services {
+		_, ports[name] = s.VariableValue("port")
+	}
+	want := map[string]string{"db": "10001", "payments": "10002", "cache": "10003", "scratch": "10004", "search": "10005"}
+	if !reflect.DeepEqual(ports, want) {
+		t.Errorf("Load() ports = %v, want %v", ports, want)
+	}
+	if db := c.Services["db"]; !db.Enable || db.Command == "" {
+		t.Errorf("db = %+v, want the included service enabled by the configuration file", db)
+	}
+	if len(c.Include) != 4 {
+		t.Errorf("Load() includes = %v, want the 3 shared and 1 local include", c.Include)
+	}
+	var got []string
+	for _, notice := range notices {
+		got = append(got, notice.String())
+	}
+	if want := []string{"services.db: the configuration file overrides the service of " + filepath.Join(dir, "tbm.d/10-data.yaml")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() notices = %v, want %v", got, want)
+	}
+}
+
+func TestLoad_IncludeErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   map[string]string
+		fetcher URLFetcher
+		wantErr string
+		// wantServices and wantNotice are checked if the configuration loads despite the include
+		wantServices []string
+		wantNotice   string
+	}{
+		{
+			name: "duplicate service",
+			files: map[string]string{
+				"tbm.yaml": "include:\n    - a.yaml\n    - b.yaml\n",
+				"a.yaml":   service("db", 10001),
+				"b.yaml":   service("db", 10002),
+			},
+			wantErr: "service db is defined in both",
+		},
+		{
+			name:    "missing file",
+			files:   map[string]string{"tbm.yaml": "include:\n    - missing.yaml\n"},
+			wantErr: "doesn't exist",
+		},
+		{
+			name: "nested include",
+			files: map[string]string{
+				"tbm.yaml": "include:\n    - a.yaml\n",
+				"a.yaml":   "include:\n    - b.yaml\n",
+			},
+			wantErr: "can't include other files",
+		},
+		{
+			name:    "url without fetcher",
+			files:   map[string]string{"tbm.yaml": "include:\n    - https://example.com/a.yaml\n"},
+			wantErr: "URLs aren't supported",
+		},
+		{
+			name:    "url not found",
+			files:   map[string]string{"tbm.yaml": "include:\n    - https://example.com/a.yaml\n"},
+			fetcher: mapFetcher{},
+			wantErr: "404 Not Found",
+		},
+		{
+			name: "unreachable url without cached copy",
+			files: map[string]string{
+				"tbm.yaml": "version: 2\ninclude:\n    - https://example.com/a.yaml\n    - b.yaml\n" + service("db", 10001),
+				"b.yaml":   service("search", 10002),
+			},
+			fetcher:      unreachableFetcher{},
+			wantServices: []string{"db", "search"},
+			wantNotice:   "include: skipped https://example.com/a.yaml, its services are missing: it can't be reached and there is no cached copy: fetching https://example.com/a.yaml failed: no route to host",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			writeFiles(t, dir, tt.files)
+			c, notices, err := Load(filepath.Join(dir, "tbm.yaml"), tt.fetcher)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Load() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Load() error = %v", err)
+			}
+			var services []string
+			for name := range c.Services {
+				services = append(services, name)
+			}
+			sort.Strings(services)
+			if !reflect.DeepEqual(services, tt.wantServices) {
+				t.Errorf("Load() services = %v, want %v", services, tt.wantServices)
+			}
+			var got []string
+			for _, notice := range notices {
+				got = append(got, notice.String())
+			}
+			if want := []string{tt.wantNotice}; !reflect.DeepEqual(got, want) {
+				t.Errorf("Load() notices = %v, want %v", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,221 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Notice describes how the local file changed the shared configuration
+type Notice struct {
+	// Path is the key that was changed, like services.db.enable
+	Path    string
+	Message string
+	// Conflict is set if the local value has another type than the shared one, the local value is used anyway
+	Conflict bool
+}
+
+func (n Notice) String() string {
+	return fmt.Sprintf("%s: %s", n.Path, n.Message)
+}
+
+// LocalPath returns the path of the local file that is merged on top of a configuration file, like ~/.tbm.local.yaml
+// for ~/.tbm.yaml
+func LocalPath(path string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + ".local" + ext
+}
+
+// Load reads a configuration file with its includes and merges the local file on top of it, if it exists. Included
+// files are merged first, the configuration file and then the local file take precedence over them. The notices
+// describe which included and shared values were changed. URLs are included with the fetcher.
+func Load(path string, fetcher URLFetcher) (Configuration, []Notice, error) {
+	return LoadChanged(path, "", nil, fetcher)
+}
+
+// LoadChanged loads a configuration file like Load, but the content of the file at changed, the configuration file or
+// its local file, is b. It's used to check a change before it's written.
+func LoadChanged(path string, changed string, b []byte, fetcher URLFetcher) (Configuration, []Notice, error) {
+	read := func(p string) (*yaml.Node, error) {
+		if p == changed {
+			return parseNode(p, b)
+		}
+		return readNode(p)
+	}
+	var configuration Configuration
+	base, err := read(path)
+	if err != nil {
+		return configuration, nil, err
+	}
+	local, err := read(LocalPath(path))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return configuration, nil, err
+	}
+
+	var notices []Notice
+	for _, file := range []struct {
+		name string
+		node *yaml.Node
+	}{{name: "configuration file", node: base}, {name: "local file", node: local}} {
+		if file.node == nil {
+			continue
+		}
+		applied, err := Migrate(file.node)
+		if err != nil {
+			return configuration, nil, fmt.Errorf("%s: %w", file.name, err)
+		}
+		if len(applied) > 0 {
+			notices = append(notices, Notice{Path: "version", Message: fmt.Sprintf("the %s has an old format, run tbm config migrate to update it", file.name)})
+		}
+	}
+	list, err := includes(base)
+	if err != nil {
+		return configuration, nil, err
+	}
+	if local != nil {
+		localList, err := includes(local)
+		if err != nil {
+			return configuration, nil, err
+		}
+		list = append(list, localList...)
+		// The includes of the local file are added to the shared ones instead of replacing them, its version only
+		// applies to itself
+		for _, key := range []string{"include", "version"} {
+			if index := mappingIndex(local, key); index >= 0 {
+				local.Content = append(local.Content[:index:index], local.Content[index+2:]...)
+			}
+		}
+	}
+	if len(list) > 0 {
+		fragments, skipped, err := readIncludes(list, filepath.Dir(path), fetcher)
+		if err != nil {
+			return configuration, nil, err
+		}
+		notices = append(notices, skipped...)
+		combined, sources, err := mergeIncludes(fragments)
+		if err != nil {
+			return configuration, nil, err
+		}
+		for _, name := range serviceNames(base) {
+			if source, ok := sources[name]; ok {
+				notices = append(notices, Notice{Path: "services." + name, Message: "the configuration file overrides the service of " + source})
+			}
+		}
+		Merge(combined, base)
+		base = combined
+	}
+	if local != nil {
+		notices = append(notices, Merge(base, local)...)
+	}
+	if err := base.Decode(&configuration); err != nil {
+		return configuration, nil, err
+	}
+	configuration.Include = list
+	return configuration, notices, nil
+}
+
+// readNode parses a YAML file, an empty file is an empty mapping
+func readNode(path string) (*yaml.Node, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return parseNode(path, b)
+}
+
+// parseNode parses the content of a YAML file, an empty file is an empty mapping
+func parseNode(path string, b []byte) (*yaml.Node, error) {
+	var document yaml.Node
+	if err := yaml.Unmarshal(b, &document); err != nil {
+		return nil, fmt.Errorf("invalid configuration file %s: %w", path, err)
+	}
+	if len(document.Content) == 0 {
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}, nil
+	}
+	return document.Content[0], nil
+}
+
+// Merge merges the local mapping into the base mapping. Mappings, like the variables of a service, are merged key by
+// key and all other values are replaced. Both have to be migrated to the current version.
+func Merge(base *yaml.Node, local *yaml.Node) []Notice {
+	return mergeMapping(base, local, "")
+}
+
+// mergeMapping merges the keys of local into base
+func mergeMapping(base *yaml.Node, local *yaml.Node, path string) []Notice {
+	var notices []Notice
+	local = resolveAlias(local)
+	for i := 0; i+1 < len(local.Content); i += 2 {
+		key, value := local.Content[i], local.Content[i+1]
+		keyPath := key.Value
+		if path != "" {
+			keyPath = path + "." + key.Value
+		}
+		index := mappingIndex(base, key.Value)
+		if index < 0 {
+			base.Content = append(base.Content, key, value)
+			if path == "services" {
+				notices = append(notices, Notice{Path: keyPath, Message: "service only exists in the local file"})
+			}
+			continue
+		}
+		notices = append(notices, mergeValue(base, index+1, value, keyPath)...)
+	}
+	return notices
+}
+
+// mergeValue merges local into the value at index of the base mapping
+func mergeValue(base *yaml.Node, index int, local *yaml.Node, path string) []Notice {
+	existing, local := resolveAlias(base.Content[index]), resolveAlias(local)
+	switch {
+	case existing.Kind == yaml.MappingNode && local.Kind == yaml.MappingNode:
+		// Aliased mappings are copied, so the merge doesn't change the anchor
+		merged := *existing
+		merged.Content = append([]*yaml.Node(nil), existing.Content...)
+		merged.Anchor = ""
+		base.Content[index] = &merged
+		return mergeMapping(&merged, local, path)
+	case existing.Kind != local.Kind:
+		base.Content[index] = local
+		return []Notice{{Path: path, Message: "the local value has another type than the shared one and replaces it", Conflict: true}}
+	case equalNodes(existing, local):
+		return []Notice{{Path: path, Message: "the local value is the same as the shared one"}}
+	}
+	base.Content[index] = local
+	return []Notice{{Path: path, Message: "the local value shadows the shared one"}}
+}
+
+// mappingIndex returns the index of the key in a mapping, or -1
+func mappingIndex(mapping *yaml.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+// resolveAlias returns the node an alias points to
+func resolveAlias(node *yaml.Node) *yaml.Node {
+	for node.Kind == yaml.AliasNode && node.Alias != nil {
+		node = node.Alias
+	}
+	return node
+}
+
+// equalNodes compares the values of two nodes, ignoring comments and positions
+func equalNodes(a *yaml.Node, b *yaml.Node) bool {
+	a, b = resolveAlias(a), resolveAlias(b)
+	if a.Kind != b.Kind || a.Value != b.Value || len(a.Content) != len(b.Content) {
+		return false
+	}
+	for i := range a.Content {
+		if !equalNodes(a.Content[i], b.Content[i]) {
+			return false
+		}
+	}
+	return true
+}
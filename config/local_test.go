@@ -0,0 +1,82 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestLoad_Local(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tbm.yaml")
+	shared := `services:
+    db:
+      command: cloud_sql_proxy -instances=prod-db=tcp:{{.port}}
+      environment: prod
+      enable: false
+      variables:
+        - port: 10001
+    cache:
+      command: redis-proxy {{.port}}
+      environment: prod
+      enable: true
+      variables:
+        - port: 10002
+      hooks:
+        pre_start:
+          command: gcloud auth print-access-token
+          timeout: 5s
+`
+	local := `services:
+    db:
+      enable: true
+      variables:
+        - port: 11001
+    cache:
+      environment: prod
+      hooks:
+        pre_start: true
+    scratch:
+      command: psql -p {{.port}}
+      environment: dev
+      enable: true
+      variables:
+        - port: 12001
+`
+	if err := os.WriteFile(path, []byte(shared), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "tbm.local.yaml"), []byte(local), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	c, notices, err := Load(path, nil)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	db := c.Services["db"]
+	if _, port := db.VariableValue("port"); !db.Enable || port != "11001" || db.Command == "" {
+		t.Errorf("db = %+v, want it enabled on port 11001 with the shared command", db)
+	}
+	if !c.Services["scratch"].Valid() {
+		t.Errorf("private service from the local file isn't valid")
+	}
+
+	var got []string
+	for _, notice := range notices {
+		got = append(got, notice.String())
+	}
+	want := []string{
+		"version: the configuration file has an old format, run tbm config migrate to update it",
+		"version: the local file has an old format, run tbm config migrate to update it",
+		"services.db.enable: the local value shadows the shared one",
+		"services.db.variables.port: the local value shadows the shared one",
+		"services.cache.environment: the local value is the same as the shared one",
+		"services.cache.hooks.pre_start: the local value has another type than the shared one and replaces it",
+		"services.scratch: service only exists in the local file",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Load() notices = %v, want %v", got, want)
+	}
+}
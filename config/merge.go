@@ -0,0 +1,146 @@
+package config
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+)
+
+// MergeRemote does a three-way merge of the local configuration file with a new version of the remote file. Base is
+// the remote version the local file was last updated from. Values the user didn't change are updated, local changes
+// like enable flags and added services are kept. If the user and the remote changed the same value, the local value
+// is kept and a conflict is reported. Without a base, only the local enable flags and services are kept.
+func MergeRemote(base *yaml.Node, local *yaml.Node, remote *yaml.Node) []Notice {
+	if base == nil {
+		base = assumedBase(local, remote)
+	}
+	return mergeThreeWay(resolveAlias(base), resolveAlias(local), resolveAlias(remote), "")
+}
+
+// assumedBase is the base if it's unknown: the local file with the enable flags of the remote, so only the enable
+// flags are local changes
+func assumedBase(local *yaml.Node, remote *yaml.Node) *yaml.Node {
+	base := copyNode(local)
+	localServices, remoteServices := mappingValue(base, "services"), mappingValue(remote, "services")
+	if localServices == nil || remoteServices == nil {
+		return base
+	}
+	for i := 0; i+1 < len(localServices.Content); i += 2 {
+		service := resolveAlias(localServices.Content[i+1])
+		remoteService := mappingValue(remoteServices, localServices.Content[i].Value)
+		if remoteService == nil || service.Kind != yaml.MappingNode {
+			continue
+		}
+		if enable := mappingValue(remoteService, "enable"); enable != nil {
+			if index := mappingIndex(service, "enable"); index >= 0 {
+				service.Content[index+1] = enable
+			}
+		}
+	}
+	return base
+}
+
+// mergeThreeWay merges the changes between base and remote into the local mapping
+func mergeThreeWay(base *yaml.Node, local *yaml.Node, remote *yaml.Node, path string) []Notice {
+	var notices []Notice
+	for i := 0; i+1 < len(remote.Content); i += 2 {
+		key, r := remote.Content[i].Value, remote.Content[i+1]
+		keyPath := joinPath(path, key)
+		b, l := mappingValue(base, key), mappingValue(local, key)
+		switch {
+		case l == nil && b == nil:
+			local.Content = append(local.Content, remote.Content[i], r)
+			notices = append(notices, Notice{Path: keyPath, Message: "added by the remote"})
+		case l == nil && equalNodes(b, r):
+			// Removed locally and unchanged by the remote
+		case l == nil:
+			notices = append(notices, Notice{Path: keyPath, Message: "changed by the remote but removed locally, it stays removed", Conflict: true})
+		case equalNodes(l, r):
+		case b != nil && equalNodes(l, b):
+			local.Content[mappingIndex(local, key)+1] = r
+			notices = append(notices, Notice{Path: keyPath, Message: "updated from the remote"})
+		case b != nil && equalNodes(r, b):
+			// Only changed locally
+		case resolveAlias(l).Kind == yaml.MappingNode && resolveAlias(r).Kind == yaml.MappingNode:
+			merged := copyNode(l)
+			local.Content[mappingIndex(local, key)+1] = merged
+			// Without a base both added the key, so everything they don't agree on is a conflict
+			baseMapping := &yaml.Node{Kind: yaml.MappingNode}
+			if b != nil && resolveAlias(b).Kind == yaml.MappingNode {
+				baseMapping = resolveAlias(b)
+			}
+			notices = append(notices, mergeThreeWay(baseMapping, merged, resolveAlias(r), keyPath)...)
+		default:
+			notices = append(notices, Notice{Path: keyPath, Message: "changed locally and by the remote, the local value is kept", Conflict: true})
+		}
+	}
+
+	// Keys only in the local file were either added locally or removed by the remote
+	var content []*yaml.Node
+	for i := 0; i+1 < len(local.Content); i += 2 {
+		key, l := local.Content[i].Value, local.Content[i+1]
+		if mappingValue(remote, key) == nil {
+			if b := mappingValue(base, key); b != nil {
+				if equalNodes(l, b) {
+					notices = append(notices, Notice{Path: joinPath(path, key), Message: "removed by the remote"})
+					continue
+				}
+				notices = append(notices, Notice{Path: joinPath(path, key), Message: "removed by the remote but changed locally, it's kept", Conflict: true})
+			}
+		}
+		content = append(content, local.Content[i], l)
+	}
+	local.Content = content
+	return notices
+}
+
+// ChangedSettings returns the top-level keys of two configuration file nodes whose values differ, like services or
+// environments, in the order of the keys of after followed by the removed ones. Comments are ignored.
+func ChangedSettings(before *yaml.Node, after *yaml.Node) []string {
+	var changed []string
+	for _, keys := range []struct{ from, other *yaml.Node }{{from: after, other: before}, {from: before, other: after}} {
+		from := resolveAlias(keys.from)
+		for i := 0; i+1 < len(from.Content); i += 2 {
+			key := from.Content[i].Value
+			other := mappingValue(keys.other, key)
+			switch {
+			case other == nil:
+				changed = append(changed, key)
+			case keys.from == after && !equalNodes(from.Content[i+1], other):
+				changed = append(changed, key)
+			}
+		}
+	}
+	return changed
+}
+
+// mappingValue returns the value of a key in a mapping, or nil
+func mappingValue(mapping *yaml.Node, key string) *yaml.Node {
+	if mapping == nil {
+		return nil
+	}
+	mapping = resolveAlias(mapping)
+	if index := mappingIndex(mapping, key); index >= 0 {
+		return mapping.Content[index+1]
+	}
+	return nil
+}
+
+// copyNode returns a deep copy of a node, aliases are replaced by copies of what they point to
+func copyNode(node *yaml.Node) *yaml.Node {
+	node = resolveAlias(node)
+	c := *node
+	c.Anchor = ""
+	c.Content = nil
+	for _, child := range node.Content {
+		c.Content = append(c.Content, copyNode(child))
+	}
+	return &c
+}
+
+// joinPath appends a key to the path of its mapping
+func joinPath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return fmt.Sprintf("%s.%s", path, key)
+}
@@ -0,0 +1,197 @@
+package config
+
+import (
+	"gopkg.in/yaml.v3"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestMergeRemote(t *testing.T) {
+	base := `services:
+    db:
+      command: cloud_sql_proxy -instances=db=tcp:{{.port}}
+      environment: prod
+      enable: false
+      variables:
+        - port: 10001
+    cache:
+      command: redis-proxy {{.port}}
+      environment: prod
+      enable: false
+      variables:
+        - port: 10002
+    legacy:
+      command: legacy-proxy {{.port}}
+      environment: prod
+      enable: false
+      variables:
+        - port: 10003
+`
+	local := `services:
+    db:
+      command: cloud_sql_proxy -instances=db=tcp:{{.port}}
+      environment: prod
+      # I need this one every day
+      enable: true
+      variables:
+        - port: 10001
+    cache:
+      command: redis-proxy --verbose {{.port}}
+      environment: prod
+      enable: false
+      variables:
+        - port: 10002
+    legacy:
+      command: legacy-proxy {{.port}}
+      environment: prod
+      enable: false
+      variables:
+        - port: 10003
+    mine:
+      command: psql -p {{.port}}
+      environment: dev
+      enable: true
+      variables:
+        - port: 11001
+`
+	remote := `services:
+    db:
+      command: cloud_sql_proxy -instances=db-v2=tcp:{{.port}}
+      environment: prod
+      enable: false
+      variables:
+        - port: 10001
+    cache:
+      command: redis-proxy --tls {{.port}}
+      environment: prod
+      enable: false
+      variables:
+        - port: 10002
+    mine:
+      command: psql -h remote -p {{.port}}
+      environment: dev
+      enable: true
+      variables:
+        - port: 11001
+    search:
+      command: es-proxy {{.port}}
+      environment: prod
+      enable: false
+      variables:
+        - port: 10004
+`
+	nodes := make([]*yaml.Node, 3)
+	for i, in := range []string{base, local, remote} {
+		var document yaml.Node
+		if err := yaml.Unmarshal([]byte(in), &document); err != nil {
+			t.Fatal(err)
+		}
+		nodes[i] = document.Content[0]
+	}
+	notices := MergeRemote(nodes[0], nodes[1], nodes[2])
+
+	var merged Configuration
+	if err := nodes[1].Decode(&merged); err != nil {
+		t.Fatal(err)
+	}
+	if db := merged.Services["db"]; !db.Enable || db.Command != "cloud_sql_proxy -instances=db-v2=tcp:{{.port}}" {
+		t.Errorf("db = %+v, want it enabled locally with the remote command", db)
+	}
+	if cache := merged.Services["cache"]; cache.Command != "redis-proxy --verbose {{.port}}" {
+		t.Errorf("cache command = %q, want the local command to be kept", cache.Command)
+	}
+	if _, ok := merged.Services["legacy"]; ok {
+		t.Errorf("legacy was removed by the remote, but it's still there")
+	}
+	if mine := merged.Services["mine"]; mine.Command != "psql -p {{.port}}" {
+		t.Errorf("mine command = %q, want the local command of the service added by both", mine.Command)
+	}
+	for _, name := range []string{"mine", "search"} {
+		if _, ok := merged.Services[name]; !ok {
+			t.Errorf("service %s is missing", name)
+		}
+	}
+
+	var got []string
+	for _, notice := range notices {
+		got = append(got, notice.String())
+	}
+	want := []string{
+		"services.db.command: updated from the remote",
+		"services.cache.command: changed locally and by the remote, the local value is kept",
+		"services.mine.command: changed locally and by the remote, the local value is kept",
+		"services.search: added by the remote",
+		"services.legacy: removed by the remote",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("MergeRemote() notices = %v, want %v", got, want)
+	}
+
+	out, err := yaml.Marshal(nodes[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(out), "# I need this one every day") {
+		t.Errorf("comment of the local file is lost:\n%s", out)
+	}
+}
+
+func TestChangedSettings(t *testing.T) {
+	local := `version: 2
+# protected since the incident
+environments:
+    prod:
+      protected: true
+services:
+    db:
+      command: proxy
+`
+	remote := `version: 2
+environments:
+    prod:
+      protected: true
+      max_lifetime: 4h
+hooks:
+    post_start: notify-send up
+services:
+    db:
+      command: proxy
+`
+	parse := func(in string) *yaml.Node {
+		var document yaml.Node
+		if err := yaml.Unmarshal([]byte(in), &document); err != nil {
+			t.Fatal(err)
+		}
+		return document.Content[0]
+	}
+	before, merged := parse(local), parse(local)
+	if got := ChangedSettings(before, merged); got != nil {
+		t.Errorf("ChangedSettings() of the same file = %v, want none", got)
+	}
+	MergeRemote(parse(local), merged, parse(remote))
+	if got, want := ChangedSettings(before, merged), []string{"environments", "hooks"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ChangedSettings() = %v, want %v", got, want)
+	}
+	if got, want := ChangedSettings(parse(remote), parse(local)), []string{"environments", "hooks"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("ChangedSettings() with a removed key = %v, want %v", got, want)
+	}
+}
+
+func TestDiff(t *testing.T) {
+	old := Configuration{Services: map[string]Service{
+		"db":    {Command: "proxy {{.port}}", Environment: "prod", Variables: []map[string]string{{"port": "1001"}}},
+		"cache": {Command: "redis {{.port}}", Environment: "prod", Variables: []map[string]string{{"port": "1002"}}},
+	}}
+	new := Configuration{Services: map[string]Service{
+		"db":     {Command: "proxy {{.port}}", Environment: "prod", Variables: []map[string]string{{"port": "1011"}}},
+		"search": {Command: "es {{.port}}", Environment: "prod", Variables: []map[string]string{{"port": "1003"}}},
+	}}
+	var got []string
+	for _, change := range Diff(old, new) {
+		got = append(got, change.Service+" "+string(change.Kind))
+	}
+	if want := []string{"cache removed", "db changed", "search added"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Diff() = %v, want %v", got, want)
+	}
+}
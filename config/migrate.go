@@ -0,0 +1,121 @@
+package config
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"strconv"
+)
+
+// CurrentVersion is the version of the configuration file format this tbm writes. Files without version are version 1.
+const CurrentVersion = 2
+
+// migration changes a configuration file from one version to the next
+type migration struct {
+	description string
+	// migrate returns true if it changed the node
+	migrate func(node *yaml.Node) (bool, error)
+}
+
+// migrations are the migrations from version 1 on, the one at index i migrates version i+1
+var migrations = []migration{
+	{description: "variables are a mapping by name instead of a list", migrate: variablesToMapping},
+}
+
+// Version returns the version of a configuration file node
+func Version(node *yaml.Node) (int, error) {
+	index := mappingIndex(node, "version")
+	if index < 0 {
+		return 1, nil
+	}
+	version, err := strconv.Atoi(node.Content[index+1].Value)
+	if err != nil || version < 1 {
+		return 0, fmt.Errorf("invalid version %q", node.Content[index+1].Value)
+	}
+	if version > CurrentVersion {
+		return 0, fmt.Errorf("the configuration file has version %d, but this tbm only knows version %d, update tbm", version, CurrentVersion)
+	}
+	return version, nil
+}
+
+// Migrate changes a configuration file node to the current version and returns the descriptions of the migrations
+// that changed more than the version. Comments and the order of keys are kept.
+func Migrate(node *yaml.Node) ([]string, error) {
+	version, err := Version(node)
+	if err != nil {
+		return nil, err
+	}
+	if version == CurrentVersion {
+		return nil, nil
+	}
+	var applied []string
+	for ; version < CurrentVersion; version++ {
+		m := migrations[version-1]
+		changed, err := m.migrate(node)
+		if err != nil {
+			return nil, fmt.Errorf("can't migrate the configuration file to version %d: %w", version+1, err)
+		}
+		if changed {
+			applied = append(applied, fmt.Sprintf("version %d: %s", version+1, m.description))
+		}
+	}
+	setVersion(node, CurrentVersion)
+	return applied, nil
+}
+
+// setVersion sets the version of a configuration file node, it's added as the first key
+func setVersion(node *yaml.Node, version int) {
+	value := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: strconv.Itoa(version)}
+	if index := mappingIndex(node, "version"); index >= 0 {
+		node.Content[index+1] = value
+		return
+	}
+	key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"}
+	if len(node.Content) > 0 {
+		// The comment at the top of the file stays there
+		key.HeadComment, node.Content[0].HeadComment = node.Content[0].HeadComment, ""
+	}
+	node.Content = append([]*yaml.Node{key, value}, node.Content...)
+}
+
+// variablesToMapping turns the variables of every service from a list of mappings into one mapping
+func variablesToMapping(node *yaml.Node) (bool, error) {
+	index := mappingIndex(node, "services")
+	if index < 0 {
+		return false, nil
+	}
+	changed := false
+	services := resolveAlias(node.Content[index+1])
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		service := resolveAlias(services.Content[i+1])
+		variablesIndex := mappingIndex(service, "variables")
+		if service.Kind != yaml.MappingNode || variablesIndex < 0 {
+			continue
+		}
+		list := resolveAlias(service.Content[variablesIndex+1])
+		if list.Kind != yaml.SequenceNode {
+			continue
+		}
+		mapping := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Style: list.Style & yaml.FlowStyle}
+		for _, item := range list.Content {
+			item = resolveAlias(item)
+			if item.Kind != yaml.MappingNode {
+				return false, fmt.Errorf("variables of service %s have to be mappings", services.Content[i].Value)
+			}
+			for j := 0; j+1 < len(item.Content); j += 2 {
+				name := item.Content[j].Value
+				if mappingIndex(mapping, name) >= 0 {
+					return false, fmt.Errorf("variable %s of service %s is defined twice", name, services.Content[i].Value)
+				}
+				key := *item.Content[j]
+				if j == 0 && item.HeadComment != "" {
+					// Comments of list items belong to their first variable now
+					key.HeadComment = item.HeadComment
+				}
+				mapping.Content = append(mapping.Content, &key, item.Content[j+1])
+			}
+		}
+		service.Content[variablesIndex+1] = mapping
+		changed = true
+	}
+	return changed, nil
+}
@@ -0,0 +1,160 @@
+package config
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ImportDefaults are the settings of imported services that the imported files don't have
+type ImportDefaults struct {
+	Environment string
+	Enable      bool
+	// BasePort is the port of the first Procfile entry using $PORT, the next ones get ports in steps of 100 like with
+	// foreman and goreman
+	BasePort int
+}
+
+// DefaultBasePort is the first port foreman and goreman assign to Procfile entries
+const DefaultBasePort = 5000
+
+// procfileLine is an entry of a Procfile, like goreman parses them
+var procfileLine = regexp.MustCompile(`^([A-Za-z0-9_.-]+):\s*(.+)$`)
+
+// procfilePort is the port variable foreman and goreman set for every entry
+var procfilePort = regexp.MustCompile(`\$(PORT\b|\{PORT\})`)
+
+// ParseProcfile converts a Procfile of foreman or goreman into a configuration. $PORT in a command becomes the port
+// variable, with the port foreman would have used.
+func ParseProcfile(r io.Reader, defaults ImportDefaults) (Configuration, error) {
+	configuration := Configuration{Version: CurrentVersion, Services: make(map[string]Service)}
+	if defaults.BasePort == 0 {
+		defaults.BasePort = DefaultBasePort
+	}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		match := procfileLine.FindStringSubmatch(text)
+		if match == nil {
+			return configuration, fmt.Errorf("line %d isn't a Procfile entry like \"web: command\"", line)
+		}
+		name, command := match[1], strings.TrimSpace(match[2])
+		if _, ok := configuration.Services[name]; ok {
+			return configuration, fmt.Errorf("line %d: %s is defined twice", line, name)
+		}
+		service := Service{Command: command, Environment: defaults.Environment, Enable: defaults.Enable}
+		if procfilePort.MatchString(command) {
+			service.Command = procfilePort.ReplaceAllString(command, "{{.port}}")
+			port := defaults.BasePort + 100*len(configuration.order)
+			service.Variables = []map[string]string{{"port": strconv.Itoa(port)}}
+		}
+		configuration.Services[name] = service
+		configuration.order = append(configuration.order, name)
+	}
+	if err := scanner.Err(); err != nil {
+		return configuration, err
+	}
+	if len(configuration.Services) == 0 {
+		return configuration, errors.New("the Procfile doesn't have any entries")
+	}
+	return configuration, nil
+}
+
+// WriteProcfile writes services as a Procfile for foreman or goreman, with the values of the variables in their
+// commands. Values of secret variables are referenced as environment variables like $TBM_DB_PASSWORD instead and are
+// returned by their name, to be written to the .env file. Variables from providers have to be resolved first.
+func (s Configuration) WriteProcfile(w io.Writer, names []string) (map[string]string, error) {
+	secrets := make(map[string]string)
+	for _, name := range names {
+		if !procfileLine.MatchString(name + ": x") {
+			return nil, fmt.Errorf("%s can't be the name of a Procfile entry", name)
+		}
+		service := s.Services[name]
+		command, err := service.ExportedCommand(service.Command, func(variable string) string {
+			return environmentName("TBM_" + name + "_" + variable)
+		}, secrets)
+		if err != nil {
+			return nil, fmt.Errorf("can't export %s: %w", name, err)
+		}
+		if strings.Contains(command, "\n") {
+			return nil, fmt.Errorf("can't export %s: its command has more than one line", name)
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s\n", name, command); err != nil {
+			return nil, err
+		}
+	}
+	return secrets, nil
+}
+
+// WriteDotenv writes values as a .env file, which foreman and goreman read for the environment of the commands. The
+// values are single quoted, so they are used as they are.
+func WriteDotenv(w io.Writer, source string, values map[string]string) error {
+	if _, err := fmt.Fprintf(w, "%s\n", GeneratedDotenv+source); err != nil {
+		return err
+	}
+	names := make([]string, 0, len(values))
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		value := values[name]
+		if strings.ContainsAny(value, "'\n") {
+			return fmt.Errorf("the value of %s can't be written to a .env file, it contains a quote or a line break", name)
+		}
+		if _, err := fmt.Fprintf(w, "%s='%s'\n", name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// GeneratedDotenv starts the first line of .env files written by tbm, other .env files aren't overwritten
+const GeneratedDotenv = "# Generated by tbm export procfile from "
+
+// ExportedCommand returns a command of the service, like its own or the one of a hook, with the values of its variables
+// to be written to files of other tools. Secret values are kept out of the command: the placeholder becomes a
+// reference to the environment variable named by env, and the value is added to secrets by that name. Variables from
+// providers have to be resolved first, and prompted variables need an answer.
+func (s Service) ExportedCommand(command string, env func(name string) string, secrets map[string]string) (string, error) {
+	for name, variable := range s.Definitions {
+		if _, value := s.VariableValue(name); value != "" {
+			continue
+		}
+		if variable.Prompt != "" {
+			return "", fmt.Errorf("variable %s is asked for when it's started and has no answer yet, answer it with tbm start", name)
+		}
+		if variable.From != "" {
+			return "", fmt.Errorf("variable %s from %s has no value", name, variable.From)
+		}
+	}
+	return s.interpolate(command, func(name string, value string) string {
+		if !s.Secret(name) {
+			return value
+		}
+		reference := env(name)
+		secrets[reference] = value
+		return "${" + reference + "}"
+	})
+}
+
+// environmentName turns a name into the name of an environment variable, like TBM_DB_PASSWORD for TBM_db_password
+func environmentName(name string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z':
+			return r - 'a' + 'A'
+		case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+			return r
+		}
+		return '_'
+	}, name)
+}
@@ -0,0 +1,129 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"regexp"
+	"sort"
+)
+
+// Prompt is a variable whose value is asked from the user when tbm starts. Services using a variable with the same
+// name share the answer.
+type Prompt struct {
+	Name     string
+	Variable Variable
+	// Services are the names of the services using the variable
+	Services []string
+}
+
+// Prompts returns the variables of valid services that are asked from the user, sorted by name
+func (s Configuration) Prompts() []Prompt {
+	prompts := make(map[string]*Prompt)
+	for key, service := range s.Services {
+		if !service.Valid() {
+			continue
+		}
+		for name, variable := range service.Definitions {
+			if variable.Prompt == "" {
+				continue
+			}
+			prompt, ok := prompts[name]
+			if !ok {
+				prompt = &Prompt{Name: name, Variable: service.Variable(name)}
+				prompts[name] = prompt
+			}
+			prompt.Services = append(prompt.Services, key)
+		}
+	}
+	var sorted []Prompt
+	for _, prompt := range prompts {
+		sort.Strings(prompt.Services)
+		sorted = append(sorted, *prompt)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted
+}
+
+// Answer sets the value of a prompted variable in all services using it
+func (s Configuration) Answer(name string, value string) {
+	for _, service := range s.Services {
+		if service.Definitions[name].Prompt == "" {
+			continue
+		}
+		for _, variables := range service.Variables {
+			if _, ok := variables[name]; ok {
+				variables[name] = value
+			}
+		}
+	}
+}
+
+// CheckAnswer returns an error if the answer to a prompt is empty or doesn't match the validation of the variable
+func (v Variable) CheckAnswer(answer string) error {
+	if answer == "" {
+		return errors.New("the value can't be empty")
+	}
+	if v.Validation == "" {
+		return nil
+	}
+	re, err := regexp.Compile("^(?:" + v.Validation + ")$")
+	if err != nil {
+		return err
+	}
+	if !re.MatchString(answer) {
+		return fmt.Errorf("the value has to match %s", v.Validation)
+	}
+	return nil
+}
+
+// RememberedAnswers returns the answers to prompts that the local file at path remembers, by variable name. An answer
+// is the value of the variable in one of the services using it, there are none if the file doesn't exist.
+func RememberedAnswers(path string, prompts []Prompt) (map[string]string, error) {
+	answers := make(map[string]string)
+	node, err := readNode(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return answers, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := Migrate(node); err != nil {
+		return nil, fmt.Errorf("local file: %w", err)
+	}
+	for _, prompt := range prompts {
+		for _, service := range prompt.Services {
+			value := variableNode(node, service, prompt.Name)
+			if value != nil && resolveAlias(value).Kind == yaml.MappingNode {
+				value = mappingValue(value, "value")
+			}
+			if value != nil && resolveAlias(value).Kind == yaml.ScalarNode && resolveAlias(value).Value != "" {
+				answers[prompt.Name] = resolveAlias(value).Value
+				break
+			}
+		}
+	}
+	return answers, nil
+}
+
+// RememberAnswer sets the answer as the value of the variable in all services using it, in the node of a local file.
+// The settings of the variable stay in the shared configuration file.
+func RememberAnswer(node *yaml.Node, prompt Prompt, answer string) error {
+	for _, service := range prompt.Services {
+		path := []string{"services", service, "variables", prompt.Name}
+		existing := variableNode(node, service, prompt.Name)
+		if existing == nil || resolveAlias(existing).Kind == yaml.MappingNode {
+			path = append(path, "value")
+		}
+		if err := SetValue(node, path, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: answer}); err != nil {
+			return fmt.Errorf("can't remember the answer of %s for %s: %w", prompt.Name, service, err)
+		}
+	}
+	return nil
+}
+
+// variableNode returns the node of a variable of a service in a configuration file node, or nil
+func variableNode(node *yaml.Node, service string, name string) *yaml.Node {
+	return mappingValue(mappingValue(mappingValue(mappingValue(node, "services"), service), "variables"), name)
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestConfiguration_Prompts(t *testing.T) {
+	in := `services:
+    bastion:
+      command: ssh -L {{.port}}:db:5432 {{.user}}@bastion
+      environment: prod
+      enable: true
+      variables:
+        - port: 10001
+        - user:
+            prompt: Your LDAP user
+            validate: "[a-z]+"
+            remember: true
+    grafana:
+      command: grafana-proxy --port {{.port}} --user {{.user}}
+      environment: prod
+      enable: true
+      variables:
+        - port: 10002
+        - user:
+            prompt: Your LDAP user
+`
+	var c Configuration
+	if err := yaml.Unmarshal([]byte(in), &c); err != nil {
+		t.Fatalf("Unmarshal() error = %v", err)
+	}
+	prompts := c.Prompts()
+	if len(prompts) != 1 || prompts[0].Name != "user" || !reflect.DeepEqual(prompts[0].Services, []string{"bastion", "grafana"}) {
+		t.Fatalf("Prompts() = %+v, want one prompt for user of both services", prompts)
+	}
+
+	c.Answer("user", "dewey")
+	for key, service := range c.Services {
+		got, err := service.InterpolatedCommand()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, user := service.VariableValue("user"); user != "dewey" {
+			t.Errorf("%s has command %q after the answer", key, got)
+		}
+	}
+}
+
+func TestVariable_CheckAnswer(t *testing.T) {
+	v := Variable{Prompt: "Your LDAP user", Validation: "[a-z]+"}
+	tests := []struct {
+		answer  string
+		wantErr bool
+	}{
+		{answer: "dewey"},
+		{answer: "", wantErr: true},
+		{answer: "Dewey", wantErr: true},
+		{answer: "dewey; rm -rf /", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.answer, func(t *testing.T) {
+			if err := v.CheckAnswer(tt.answer); (err != nil) != tt.wantErr {
+				t.Errorf("CheckAnswer() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestRememberAnswer(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tbm.yaml")
+	shared := `version: 2
+services:
+    bastion:
+      command: ssh -L {{.port}}:db:5432 {{.user}}@bastion
+      environment: prod
+      enable: true
+      variables:
+        port: 10001
+        user:
+          prompt: Your LDAP user
+          remember: true
+    jump:
+      command: ssh -L {{.port}}:cache:6379 {{.user}}@jump
+      environment: prod
+      enable: true
+      variables:
+        port: 10002
+        user: {prompt: Your LDAP user, remember: true}
+`
+	if err := os.WriteFile(path, []byte(shared), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	configuration, _, err := Load(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prompts := configuration.Prompts()
+	answers, err := RememberedAnswers(LocalPath(path), prompts)
+	if err != nil || len(answers) != 0 {
+		t.Fatalf("RememberedAnswers() without a local file = %v, %v", answers, err)
+	}
+
+	local := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	if err := yaml.Unmarshal([]byte("services:\n    jump:\n      enable: false\n"), local); err != nil {
+		t.Fatal(err)
+	}
+	for _, prompt := range prompts {
+		if err := RememberAnswer(local.Content[0], prompt, "dewey"); err != nil {
+			t.Fatalf("RememberAnswer() error = %v", err)
+		}
+	}
+	b, err := yaml.Marshal(local)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(LocalPath(path), b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	answers, err = RememberedAnswers(LocalPath(path), prompts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]string{"user": "dewey"}; !reflect.DeepEqual(answers, want) {
+		t.Errorf("RememberedAnswers() = %v, want %v", answers, want)
+	}
+	configuration, notices, err := Load(path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"bastion", "jump"} {
+		service := configuration.Services[name]
+		if _, value := service.VariableValue("user"); value != "dewey" {
+			t.Errorf("user of %s = %q, want the remembered answer", name, value)
+		}
+		if service.Definitions["user"].Prompt == "" {
+			t.Errorf("the local file replaced the prompt of %s", name)
+		}
+	}
+	for _, notice := range notices {
+		if notice.Conflict {
+			t.Errorf("remembering an answer caused a conflict: %s", notice)
+		}
+	}
+}
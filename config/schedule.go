@@ -0,0 +1,225 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Schedule is a parsed `schedule` value of a service. It's either a fixed interval like "50m" or a cron expression
+// with the five standard fields (minute, hour, day of month, month, day of week).
+type Schedule struct {
+	interval time.Duration
+	minute   uint64
+	hour     uint64
+	dom      uint64
+	month    uint64
+	dow      uint64
+	// domStar and dowStar track if the day fields were unrestricted, cron matches either of them if both are set
+	domStar bool
+	dowStar bool
+}
+
+// cronField describes the allowed range of a single cron field
+type cronField struct {
+	name     string
+	min, max int
+}
+
+var cronFields = []cronField{
+	{name: "minute", min: 0, max: 59},
+	{name: "hour", min: 0, max: 23},
+	{name: "day of month", min: 1, max: 31},
+	{name: "month", min: 1, max: 12},
+	{name: "day of week", min: 0, max: 6},
+}
+
+// ParseSchedule parses an interval ("50m", "@every 1h") or a cron expression ("*/5 * * * *")
+func ParseSchedule(s string) (*Schedule, error) {
+	s = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(s), "@every"))
+	if s == "" {
+		return nil, errors.New("empty schedule")
+	}
+	fields := strings.Fields(s)
+	if len(fields) == 1 {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid schedule interval %q: %w", s, err)
+		}
+		if d <= 0 {
+			return nil, fmt.Errorf("schedule interval has to be positive: %q", s)
+		}
+		return &Schedule{interval: d}, nil
+	}
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("invalid cron expression %q, expected %d fields", s, len(cronFields))
+	}
+
+	var sched Schedule
+	targets := []*uint64{&sched.minute, &sched.hour, &sched.dom, &sched.month, &sched.dow}
+	for i, field := range fields {
+		bits, err := parseCronField(field, cronFields[i])
+		if err != nil {
+			return nil, err
+		}
+		*targets[i] = bits
+	}
+	sched.domStar = strings.HasPrefix(fields[2], "*")
+	sched.dowStar = strings.HasPrefix(fields[4], "*")
+	return &sched, nil
+}
+
+// parseCronField parses a comma separated list of values, ranges and steps into a bitset
+func parseCronField(field string, f cronField) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		i := strings.Index(part, "/")
+		if i >= 0 {
+			var err error
+			step, err = strconv.Atoi(part[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("invalid step in %s field: %q", f.name, part)
+			}
+			part = part[:i]
+		}
+		start, end := f.min, f.max
+		switch {
+		case part == "*":
+		case strings.Contains(part, "-"):
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if start, err = strconv.Atoi(bounds[0]); err != nil {
+				return 0, fmt.Errorf("invalid %s field: %q", f.name, part)
+			}
+			if end, err = strconv.Atoi(bounds[1]); err != nil {
+				return 0, fmt.Errorf("invalid %s field: %q", f.name, part)
+			}
+		default:
+			v, err := strconv.Atoi(part)
+			if err != nil {
+				return 0, fmt.Errorf("invalid %s field: %q", f.name, part)
+			}
+			start, end = v, v
+			if i >= 0 {
+				// A step without a range starts at the value and goes to the end like in cron, 5/15 is 5-59/15
+				end = f.max
+				if f.name == "day of week" {
+					end = 7
+				}
+			}
+		}
+		// Sunday can be written as 7 as well, it's only included in a range if the step reaches it
+		if f.name == "day of week" && end == 7 {
+			if start == 7 {
+				start = 0
+				end = 0
+			} else {
+				if start >= f.min && (7-start)%step == 0 {
+					bits |= 1
+				}
+				end = 6
+			}
+		}
+		if start < f.min || end > f.max || start > end {
+			return 0, fmt.Errorf("%s field out of range (%d-%d): %q", f.name, f.min, f.max, part)
+		}
+		for v := start; v <= end; v += step {
+			bits |= 1 << uint(v)
+		}
+	}
+	return bits, nil
+}
+
+// IsInterval returns true if the schedule is a fixed interval instead of a cron expression
+func (s *Schedule) IsInterval() bool {
+	return s.interval > 0
+}
+
+// Interval returns the interval of a schedule that isn't a cron expression
+func (s *Schedule) Interval() time.Duration {
+	return s.interval
+}
+
+// weekdays are the names of the days of the week in calendar events of systemd, Sunday is 0 like in cron
+var weekdays = []string{"Sun", "Mon", "Tue", "Wed", "Thu", "Fri", "Sat"}
+
+// OnCalendar returns the cron expression as calendar event of systemd timers, like "Mon,Fri *-*-* 9:0,30:00"
+func (s *Schedule) OnCalendar() (string, error) {
+	if s.IsInterval() {
+		return "", errors.New("the schedule is an interval")
+	}
+	if !s.domStar && !s.dowStar {
+		// systemd would only run it on days that match both
+		return "", errors.New("cron expressions with both a day of month and a day of week can't be converted")
+	}
+	event := fmt.Sprintf("*-%s-%s %s:%s:00", calendarValues(s.month, cronFields[3], nil), calendarValues(s.dom, cronFields[2], nil),
+		calendarValues(s.hour, cronFields[1], nil), calendarValues(s.minute, cronFields[0], nil))
+	if dow := calendarValues(s.dow, cronFields[4], weekdays); dow != "*" {
+		event = dow + " " + event
+	}
+	return event, nil
+}
+
+// calendarValues returns the values of a cron field as list of a calendar event, * if all values are set
+func calendarValues(bits uint64, f cronField, names []string) string {
+	var values []string
+	for v := f.min; v <= f.max; v++ {
+		if bits&(1<<uint(v)) == 0 {
+			continue
+		}
+		if names != nil {
+			values = append(values, names[v])
+		} else {
+			values = append(values, strconv.Itoa(v))
+		}
+	}
+	if len(values) == f.max-f.min+1 {
+		return "*"
+	}
+	return strings.Join(values, ",")
+}
+
+// Next returns the next time after t the schedule is due. A zero time is returned if the cron expression never
+// matches (e.g. the 31st of February).
+func (s *Schedule) Next(t time.Time) time.Time {
+	if s.IsInterval() {
+		return t.Add(s.interval)
+	}
+
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// Give up after five years, the expression can't match at that point
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if s.month&(1<<uint(t.Month())) == 0 {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.hour&(1<<uint(t.Hour())) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if s.minute&(1<<uint(t.Minute())) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatches follows the cron convention: if both day of month and day of week are restricted, either has to match
+func (s *Schedule) dayMatches(t time.Time) bool {
+	domMatch := s.dom&(1<<uint(t.Day())) != 0
+	dowMatch := s.dow&(1<<uint(t.Weekday())) != 0
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSchedule_Next(t *testing.T) {
+	base := time.Date(2023, time.January, 10, 10, 17, 30, 0, time.UTC) // Tuesday
+	tests := []struct {
+		name     string
+		schedule string
+		want     time.Time
+	}{
+		{
+			name:     "interval",
+			schedule: "50m",
+			want:     base.Add(50 * time.Minute),
+		},
+		{
+			name:     "interval with @every prefix",
+			schedule: "@every 1h",
+			want:     base.Add(time.Hour),
+		},
+		{
+			name:     "every five minutes",
+			schedule: "*/5 * * * *",
+			want:     time.Date(2023, time.January, 10, 10, 20, 0, 0, time.UTC),
+		},
+		{
+			name:     "step without a range",
+			schedule: "5/15 * * * *",
+			want:     time.Date(2023, time.January, 10, 10, 20, 0, 0, time.UTC),
+		},
+		{
+			name:     "daily at a fixed time",
+			schedule: "30 9 * * *",
+			want:     time.Date(2023, time.January, 11, 9, 30, 0, 0, time.UTC),
+		},
+		{
+			name:     "weekdays only",
+			schedule: "0 8 * * 1-5",
+			want:     time.Date(2023, time.January, 11, 8, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "sunday written as 7",
+			schedule: "0 0 * * 7",
+			want:     time.Date(2023, time.January, 15, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "first of the month",
+			schedule: "0 0 1 * *",
+			want:     time.Date(2023, time.February, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:     "day that never exists",
+			schedule: "0 0 31 2 *",
+			want:     time.Time{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := ParseSchedule(tt.schedule)
+			if err != nil {
+				t.Fatalf("ParseSchedule() error = %v", err)
+			}
+			if got := s.Next(base); !got.Equal(tt.want) {
+				t.Errorf("Next() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseSchedule_Invalid(t *testing.T) {
+	tests := []string{
+		"",
+		"soon",
+		"-5m",
+		"* * * *",
+		"60 * * * *",
+		"*/0 * * * *",
+		"5-1 * * * *",
+	}
+	for _, tt := range tests {
+		t.Run(tt, func(t *testing.T) {
+			if _, err := ParseSchedule(tt); err == nil {
+				t.Errorf("ParseSchedule(%q) expected error", tt)
+			}
+		})
+	}
+}
+
+func TestParseCronField_DayOfWeek(t *testing.T) {
+	tests := []struct {
+		field string
+		want  []int
+	}{
+		{field: "7", want: []int{0}},
+		{field: "5-7", want: []int{0, 5, 6}},
+		{field: "2-7/2", want: []int{2, 4, 6}},
+		{field: "1-7/2", want: []int{0, 1, 3, 5}},
+		{field: "*/3", want: []int{0, 3, 6}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.field, func(t *testing.T) {
+			got, err := parseCronField(tt.field, cronFields[4])
+			if err != nil {
+				t.Fatalf("parseCronField() error = %v", err)
+			}
+			var want uint64
+			for _, day := range tt.want {
+				want |= 1 << uint(day)
+			}
+			if got != want {
+				t.Errorf("parseCronField() = %07b, want %07b", got, want)
+			}
+		})
+	}
+}
+
+func TestSchedule_OnCalendar(t *testing.T) {
+	tests := []struct {
+		schedule string
+		want     string
+		wantErr  bool
+	}{
+		{schedule: "*/15 * * * *", want: "*-*-* *:0,15,30,45:00"},
+		{schedule: "30 9 1 1,7 *", want: "*-1,7-1 9:30:00"},
+		{schedule: "5/15 * * * *", want: "*-*-* *:5,20,35,50:00"},
+		{schedule: "0 8 * * 5/2", want: "Sun,Fri *-*-* 8:0:00"},
+		{schedule: "0 8 * * 0,7", want: "Sun *-*-* 8:0:00"},
+		{schedule: "0 8 1 * 1", wantErr: true},
+		{schedule: "1h", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.schedule, func(t *testing.T) {
+			s, err := ParseSchedule(tt.schedule)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := s.OnCalendar()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("OnCalendar() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("OnCalendar() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,158 @@
+package config
+
+// Schema returns the JSON Schema of the configuration file, for editors to complete and validate it
+func Schema() map[string]interface{} {
+	duration := map[string]interface{}{
+		"type":        "string",
+		"pattern":     `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`,
+		"description": `A duration like "90s", "30m" or "1h30m"`,
+	}
+	hook := map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string", "description": "The command"},
+			schemaObject(map[string]interface{}{
+				"command": schemaString("The command"),
+				"timeout": schemaDescribed(duration, "How long the command may run before it's killed, 1m by default"),
+			}, "command"),
+		},
+	}
+	variable := map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": []string{"string", "number", "boolean"}, "description": "The value"},
+			schemaObject(map[string]interface{}{
+				"value":    schemaString("The value, or the default answer of a prompt"),
+				"secret":   schemaBool("Redact the value wherever tbm prints or records it"),
+				"from":     schemaEnum("Provider of the value, it's resolved every time the service is started", ProviderEnv, ProviderFile, ProviderCommand, ProviderStore),
+				"name":     schemaString("Environment variable of the env provider, or name of the secret in the store"),
+				"path":     schemaString("File read by the file provider"),
+				"run":      schemaString("Command whose output is the value for the command provider"),
+				"cache":    schemaDescribed(duration, "How long the output of the command is reused"),
+				"prompt":   schemaString("Question the user is asked for the value when tbm starts"),
+				"validate": schemaString("Regular expression the answer has to match"),
+				"remember": schemaBool("Remember the answer, so it's only asked once"),
+			}),
+		},
+	}
+	service := schemaObject(map[string]interface{}{
+		"command":     schemaString("Command of the service, variables are used as {{.name}}"),
+		"environment": schemaString("Environment the service belongs to, like prod or stage"),
+		"enable":      schemaBool("Start the service with tbm start"),
+		"variables": map[string]interface{}{
+			"description": "Variables of the command by name, the port variable has to be unique across services",
+			"oneOf": []interface{}{
+				map[string]interface{}{"type": "object", "additionalProperties": variable},
+				schemaList("Variables of version 1, a list of mappings", map[string]interface{}{"type": "object", "additionalProperties": variable}),
+			},
+		},
+		"type":          schemaEnum("daemon for long-running services, oneshot for tasks that run to completion", TypeDaemon, TypeOneshot),
+		"schedule":      schemaString(`Run the command periodically, an interval like "50m" or a cron expression like "*/5 * * * *"`),
+		"depends_on":    schemaList("Services that have to be ready before this one is started", map[string]interface{}{"type": "string"}),
+		"max_lifetime":  schemaDescribed(duration, "Stop the service after it ran this long"),
+		"restart_every": schemaDescribed(duration, "Restart the service periodically"),
+		"on_output": schemaList("Rules that react to lines printed by the service", schemaObject(map[string]interface{}{
+			"match":    schemaString("Regular expression matched against every line"),
+			"action":   schemaEnum("What happens when a line matches", ActionReady, ActionRestart, ActionFail, ActionRun, ActionSeverity),
+			"run":      schemaString("Command of the run action"),
+			"severity": schemaEnum("Severity of the severity action", SeverityWarning, SeverityError),
+		}, "match", "action")),
+		"hooks": schemaObject(map[string]interface{}{
+			"pre_start":  hook,
+			"post_start": hook,
+			"pre_stop":   hook,
+			"post_stop":  hook,
+		}),
+		"quiet_stdout": schemaBool("Only show the lines the service writes to standard error"),
+		"color": map[string]interface{}{
+			"description": `Color of the lines in the terminal, a name like "cyan" or "bright-cyan", a number up to 255 or "#ff8700"`,
+			"type":        []string{"string", "integer"},
+		},
+	})
+	include := map[string]interface{}{
+		"oneOf": []interface{}{
+			map[string]interface{}{"type": "string", "description": "A file, a glob like ~/.tbm.d/*.yaml or a file:// or https:// URL"},
+			schemaObject(map[string]interface{}{
+				"path": schemaString("The file, in a git repository the path of the file in it"),
+				"git":  schemaString("Path of a local git repository"),
+				"ref":  schemaString("Branch, tag or commit of the repository, HEAD by default"),
+			}, "path"),
+		},
+	}
+
+	schema := schemaObject(map[string]interface{}{
+		"version": map[string]interface{}{
+			"description": "Version of the file format, tbm config migrate updates it",
+			"type":        "integer",
+			"minimum":     1,
+			"maximum":     CurrentVersion,
+		},
+		"services": map[string]interface{}{
+			"description":          "Services by name",
+			"type":                 "object",
+			"additionalProperties": service,
+		},
+		"environments": map[string]interface{}{
+			"description": "Settings of all services of an environment, by environment name",
+			"type":        "object",
+			"additionalProperties": schemaObject(map[string]interface{}{
+				"max_lifetime": schemaDescribed(duration, "Default maximum lifetime of the services"),
+				"protected":    schemaBool("Ask for a confirmation before services are started"),
+			}),
+		},
+		"hooks": schemaObject(map[string]interface{}{
+			"before": hook,
+			"after":  hook,
+		}),
+		"logs": schemaObject(map[string]interface{}{
+			"disable": schemaBool("Don't write log files"),
+			"max_size": map[string]interface{}{
+				"description": `Size a log file is rotated at, like "10MB"`,
+				"type":        []string{"string", "integer"},
+			},
+			"max_files": map[string]interface{}{"description": "Number of rotated files kept per service", "type": "integer", "minimum": 0},
+			"buffer":    map[string]interface{}{"description": "Number of recent lines kept in memory per service", "type": "integer", "minimum": 0},
+		}),
+		"include": schemaList("Other configuration files whose services are added", include),
+	})
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "tbm configuration file"
+	return schema
+}
+
+// schemaObject is an object schema that only allows the properties
+func schemaObject(properties map[string]interface{}, required ...string) map[string]interface{} {
+	schema := map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func schemaString(description string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "description": description}
+}
+
+func schemaBool(description string) map[string]interface{} {
+	return map[string]interface{}{"type": "boolean", "description": description}
+}
+
+func schemaEnum(description string, values ...string) map[string]interface{} {
+	return map[string]interface{}{"type": "string", "enum": values, "description": description}
+}
+
+func schemaList(description string, items map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"type": "array", "items": items, "description": description}
+}
+
+// schemaDescribed returns a copy of a schema with a description
+func schemaDescribed(schema map[string]interface{}, description string) map[string]interface{} {
+	described := make(map[string]interface{}, len(schema)+1)
+	for key, value := range schema {
+		described[key] = value
+	}
+	described["description"] = description
+	return described
+}
@@ -0,0 +1,272 @@
+package config
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Unit is a generated systemd unit file
+type Unit struct {
+	// Name is the file name of the unit, like tbm-db-prod.service
+	Name    string
+	Content string
+	// Secret files contain values of secret variables, only the user may read them
+	Secret bool
+}
+
+// SystemdOptions configures the generated systemd units
+type SystemdOptions struct {
+	// Target is the name of the target unit that groups the services, like tbm-prod.target
+	Target string
+	// WorkingDirectory is the directory the commands are run in, tbm runs them in the directory it was started in
+	WorkingDirectory string
+	// Source is the configuration file the units are generated from, it's mentioned in the units
+	Source string
+	// Dir is the directory the units are written to, the environment files with the values of secret variables are
+	// kept there as well
+	Dir string
+}
+
+// systemdRestartDelay is how long systemd waits before a failed service is restarted
+const systemdRestartDelay = 5 * time.Second
+
+// unitName matches the characters systemd allows in unit names
+var unitName = regexp.MustCompile(`^[A-Za-z0-9:_.-]+$`)
+
+// UnitName returns the name of the systemd unit of a service without its suffix, like tbm-db-prod
+func (s Configuration) UnitName(name string) string {
+	if environment := s.Services[name].Environment; environment != "" {
+		return fmt.Sprintf("tbm-%s-%s", name, environment)
+	}
+	return "tbm-" + name
+}
+
+// SystemdUnits returns systemd user units of the services: one unit per service, a timer for scheduled services and a
+// target that starts all of them. Services are restarted by systemd if they fail and are stopped with SIGINT like tbm
+// does. The commands are run by the shell, the hooks and TBM_* environment variables are kept. Values of secret
+// variables are kept in an environment file of the unit that the commands reference, variables from providers have to
+// be resolved first.
+func (s Configuration) SystemdUnits(names []string, options SystemdOptions) ([]Unit, error) {
+	exported := make(map[string]bool, len(names))
+	for _, name := range names {
+		exported[name] = true
+	}
+	var units []Unit
+	var wants []string
+	for _, name := range names {
+		service := s.Services[name]
+		unit := s.UnitName(name)
+		if !unitName.MatchString(unit) {
+			return nil, fmt.Errorf("%s can't be the name of a systemd unit", unit)
+		}
+		secrets := make(map[string]string)
+		env := func(variable string) string {
+			return environmentName("TBM_VAR_" + variable)
+		}
+		// Hooks get the secret variables from the environment as well, like with tbm
+		for _, values := range service.Variables {
+			for variable, value := range values {
+				if service.Secret(variable) {
+					secrets[env(variable)] = value
+				}
+			}
+		}
+		command, err := service.ExportedCommand(service.Command, env, secrets)
+		if err != nil {
+			return nil, fmt.Errorf("can't export %s: %w", name, err)
+		}
+		schedule, err := service.ParsedSchedule()
+		if err != nil {
+			return nil, err
+		}
+
+		var b strings.Builder
+		fmt.Fprintf(&b, "# Generated by tbm from %s, changes are overwritten by tbm export systemd\n", options.Source)
+		b.WriteString("[Unit]\n")
+		fmt.Fprintf(&b, "Description=%s\n", systemdEscape(unitDescription(name, service)))
+		fmt.Fprintf(&b, "PartOf=%s\n", options.Target)
+		for _, dependency := range service.DependsOn {
+			if !exported[dependency] {
+				return nil, fmt.Errorf("%s depends on %s, which isn't exported", name, dependency)
+			}
+			fmt.Fprintf(&b, "Requires=%s.service\nAfter=%s.service\n", s.UnitName(dependency), s.UnitName(dependency))
+		}
+
+		b.WriteString("\n[Service]\n")
+		if service.IsOneshot() || schedule != nil {
+			b.WriteString("Type=oneshot\n")
+		} else {
+			b.WriteString("Type=simple\n")
+		}
+		if options.WorkingDirectory != "" {
+			fmt.Fprintf(&b, "WorkingDirectory=%s\n", systemdEscape(options.WorkingDirectory))
+		}
+		fmt.Fprintf(&b, "Environment=%s\n", strings.Join(systemdEnvironment(name, service), " "))
+		var exec strings.Builder
+		for _, hook := range []struct {
+			setting string
+			hook    *Hook
+		}{
+			{setting: "ExecStartPre", hook: service.Hooks.PreStart},
+			{setting: "ExecStart"},
+			{setting: "ExecStartPost", hook: service.Hooks.PostStart},
+			{setting: "ExecStop", hook: service.Hooks.PreStop},
+			{setting: "ExecStopPost", hook: service.Hooks.PostStop},
+		} {
+			line := command
+			if hook.setting != "ExecStart" {
+				if hook.hook == nil || hook.hook.Command == "" {
+					continue
+				}
+				if line, err = service.ExportedCommand(hook.hook.Command, env, secrets); err != nil {
+					return nil, fmt.Errorf("invalid %s hook of %s: %w", hook.setting, name, err)
+				}
+			}
+			fmt.Fprintf(&exec, "%s=/bin/sh -c %s\n", hook.setting, systemdQuote(strings.ReplaceAll(line, "$", "$$")))
+		}
+		if len(secrets) > 0 {
+			content, err := systemdEnvironmentFile(secrets, options)
+			if err != nil {
+				return nil, fmt.Errorf("can't export %s: %w", name, err)
+			}
+			units = append(units, Unit{Name: unit + ".env", Content: content, Secret: true})
+			fmt.Fprintf(&b, "EnvironmentFile=%s\n", systemdEscape(filepath.Join(options.Dir, unit+".env")))
+		}
+		b.WriteString(exec.String())
+		b.WriteString("KillSignal=SIGINT\n")
+		if service.QuietStdout {
+			b.WriteString("StandardOutput=null\n")
+		}
+		switch {
+		case schedule != nil:
+		case service.IsOneshot():
+			b.WriteString("RemainAfterExit=yes\n")
+		case service.RestartEvery > 0 && s.MaxLifetime(service) > 0 && service.RestartEvery < s.MaxLifetime(service):
+			// A unit that is always restarted can't be stopped for good after its lifetime
+			return nil, fmt.Errorf("can't export %s: systemd can't restart it every %s and stop it after its max lifetime of %s, remove restart_every or max_lifetime", name, service.RestartEvery, s.MaxLifetime(service))
+		case service.RestartEvery > 0 && s.MaxLifetime(service) == 0:
+			// The service is stopped after the runtime and always started again
+			fmt.Fprintf(&b, "Restart=always\nRestartSec=%s\nRuntimeMaxSec=%s\n", systemdSeconds(systemdRestartDelay), systemdSeconds(service.RestartEvery))
+		case s.MaxLifetime(service) > 0:
+			fmt.Fprintf(&b, "Restart=no\nRuntimeMaxSec=%s\n", systemdSeconds(s.MaxLifetime(service)))
+		default:
+			fmt.Fprintf(&b, "Restart=on-failure\nRestartSec=%s\n", systemdSeconds(systemdRestartDelay))
+		}
+
+		if schedule == nil {
+			fmt.Fprintf(&b, "\n[Install]\nWantedBy=%s\n", options.Target)
+			units = append(units, Unit{Name: unit + ".service", Content: b.String()})
+			wants = append(wants, unit+".service")
+			continue
+		}
+		units = append(units, Unit{Name: unit + ".service", Content: b.String()})
+		timer, err := systemdTimer(name, service, schedule, options)
+		if err != nil {
+			return nil, fmt.Errorf("can't export %s: %w", name, err)
+		}
+		units = append(units, Unit{Name: unit + ".timer", Content: timer})
+		wants = append(wants, unit+".timer")
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by tbm from %s, changes are overwritten by tbm export systemd\n", options.Source)
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=Services of tbm from %s\n", systemdEscape(options.Source))
+	fmt.Fprintf(&b, "Wants=%s\n", strings.Join(wants, " "))
+	b.WriteString("\n[Install]\nWantedBy=default.target\n")
+	units = append(units, Unit{Name: options.Target, Content: b.String()})
+	return units, nil
+}
+
+// systemdTimer returns the timer unit that runs a scheduled service
+func systemdTimer(name string, service Service, schedule *Schedule, options SystemdOptions) (string, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by tbm from %s, changes are overwritten by tbm export systemd\n", options.Source)
+	b.WriteString("[Unit]\n")
+	fmt.Fprintf(&b, "Description=Schedule of %s\n", systemdEscape(unitDescription(name, service)))
+	fmt.Fprintf(&b, "PartOf=%s\n", options.Target)
+	b.WriteString("\n[Timer]\n")
+	if schedule.IsInterval() {
+		// Like tbm, the service runs right away and then every interval
+		fmt.Fprintf(&b, "OnActiveSec=0\nOnUnitActiveSec=%s\n", systemdSeconds(schedule.Interval()))
+	} else {
+		event, err := schedule.OnCalendar()
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&b, "OnCalendar=%s\n", event)
+	}
+	fmt.Fprintf(&b, "\n[Install]\nWantedBy=%s\n", options.Target)
+	return b.String(), nil
+}
+
+// unitDescription describes the service in its unit
+func unitDescription(name string, service Service) string {
+	if service.Environment == "" {
+		return "tbm service " + name
+	}
+	return fmt.Sprintf("tbm service %s (%s)", name, service.Environment)
+}
+
+// systemdEnvironmentFile returns the content of the environment file with the values of secret variables
+func systemdEnvironmentFile(secrets map[string]string, options SystemdOptions) (string, error) {
+	names := make([]string, 0, len(secrets))
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var b strings.Builder
+	fmt.Fprintf(&b, "# Generated by tbm from %s, changes are overwritten by tbm export systemd\n", options.Source)
+	for _, name := range names {
+		if strings.Contains(secrets[name], "\n") {
+			return "", fmt.Errorf("the value of %s has more than one line", name)
+		}
+		fmt.Fprintf(&b, "%s=\"%s\"\n", name, strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(secrets[name]))
+	}
+	return b.String(), nil
+}
+
+// systemdEnvironment returns the quoted TBM_* environment variables tbm passes to the hooks of a service, secret
+// variables are in the environment file of the unit instead
+func systemdEnvironment(name string, service Service) []string {
+	env := []string{"TBM_SERVICE=" + name, "TBM_ENVIRONMENT=" + service.Environment}
+	if exists, port := service.VariableValue("port"); exists {
+		env = append(env, "TBM_PORT="+port)
+	}
+	var variables []string
+	for _, values := range service.Variables {
+		for variable, value := range values {
+			if service.Secret(variable) {
+				continue
+			}
+			variables = append(variables, fmt.Sprintf("TBM_VAR_%s=%s", strings.ToUpper(variable), value))
+		}
+	}
+	sort.Strings(variables)
+	env = append(env, variables...)
+	for i := range env {
+		env[i] = systemdQuote(env[i])
+	}
+	return env
+}
+
+// systemdQuote quotes a value for a unit file. In commands $ has to be escaped as $$ as well, systemd would replace it
+// with environment variables otherwise.
+func systemdQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(systemdEscape(s)) + `"`
+}
+
+// systemdEscape escapes the specifiers of systemd in a value
+func systemdEscape(s string) string {
+	return strings.ReplaceAll(s, "%", "%%")
+}
+
+// systemdSeconds formats a duration as seconds, the smallest unit tbm uses
+func systemdSeconds(d time.Duration) string {
+	seconds := int64((d + time.Second - 1) / time.Second)
+	return fmt.Sprintf("%ds", seconds)
+}
@@ -0,0 +1,266 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"regexp"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Variable is the definition of a variable. In the configuration file it's either just the value, or a mapping with
+// the value and settings like secret or the provider of the value.
+type Variable struct {
+	Value string `yaml:"value,omitempty"`
+	// Secret values are redacted wherever tbm prints or records them
+	Secret bool `yaml:"secret,omitempty"`
+	// From is the provider of the value, it's resolved every time the service is started. Values of providers are
+	// always secret.
+	From string `yaml:"from,omitempty"`
+	// Name is the environment variable read by the env provider, or the name of the value in the store
+	Name string `yaml:"name,omitempty"`
+	// Path is the file read by the file provider
+	Path string `yaml:"path,omitempty"`
+	// Run is the command whose output is the value for the command provider
+	Run string `yaml:"run,omitempty"`
+	// Cache is how long the output of the command is reused before it's run again
+	Cache time.Duration `yaml:"cache,omitempty"`
+	// Prompt is the question the user is asked for the value when tbm starts, Value is the default answer
+	Prompt string `yaml:"prompt,omitempty"`
+	// Validation is a regular expression the answer has to match
+	Validation string `yaml:"validate,omitempty"`
+	// Remember stores the answer, so it's only asked once
+	Remember bool `yaml:"remember,omitempty"`
+}
+
+// Providers of variable values
+const (
+	// ProviderEnv reads the value from an environment variable of tbm
+	ProviderEnv = "env"
+	// ProviderFile reads the value from a file, a trailing newline is removed
+	ProviderFile = "file"
+	// ProviderCommand runs a command, its output is the value
+	ProviderCommand = "command"
+	// ProviderStore decrypts the value from the local secret store
+	ProviderStore = "store"
+)
+
+// VariableResolver returns the value of a variable from its provider
+type VariableResolver interface {
+	Resolve(variable Variable) (string, error)
+}
+
+// Validate checks that the provider of a variable is known and has the settings it needs, and that prompts are
+// valid
+func (v Variable) Validate() error {
+	switch v.From {
+	case "":
+	case ProviderEnv, ProviderStore:
+		if v.Name == "" {
+			return fmt.Errorf("variables from %s need a name", v.From)
+		}
+	case ProviderFile:
+		if v.Path == "" {
+			return fmt.Errorf("variables from %s need a path", v.From)
+		}
+	case ProviderCommand:
+		if v.Run == "" {
+			return fmt.Errorf("variables from %s need a command to run", v.From)
+		}
+	default:
+		return fmt.Errorf("unknown variable provider %q", v.From)
+	}
+	if v.Prompt != "" && v.From != "" {
+		return errors.New("variables can either be asked for or come from a provider")
+	}
+	if v.Prompt == "" && (v.Validation != "" || v.Remember) {
+		return errors.New("validate and remember can only be used with prompt")
+	}
+	if _, err := regexp.Compile(v.Validation); err != nil {
+		return fmt.Errorf("invalid validate %q: %w", v.Validation, err)
+	}
+	return nil
+}
+
+// UnmarshalYAML allows variables to be written as a plain value
+func (v *Variable) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		v.Value = value.Value
+		return nil
+	}
+	type plain Variable
+	return value.Decode((*plain)(v))
+}
+
+// MarshalYAML writes variables without settings as a plain value, numbers like ports aren't quoted
+func (v Variable) MarshalYAML() (interface{}, error) {
+	if v.isPlain() {
+		if _, err := strconv.Atoi(v.Value); err == nil {
+			return &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!int", Value: v.Value}, nil
+		}
+		return v.Value, nil
+	}
+	type plain Variable
+	return plain(v), nil
+}
+
+// isPlain returns true if the variable only has a value
+func (v Variable) isPlain() bool {
+	return v == Variable{Value: v.Value}
+}
+
+// UnmarshalYAML decodes the variables of a service into their values, definitions with settings are kept separately.
+// Variables are a mapping by name, files of version 1 have a list of mappings instead.
+func (s *Service) UnmarshalYAML(value *yaml.Node) error {
+	type plain Service
+	if value.Kind != yaml.MappingNode {
+		return value.Decode((*plain)(s))
+	}
+	rest := *value
+	rest.Content = nil
+	var variables *yaml.Node
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		if value.Content[i].Value == "variables" {
+			variables = value.Content[i+1]
+			continue
+		}
+		rest.Content = append(rest.Content, value.Content[i], value.Content[i+1])
+	}
+	if err := rest.Decode((*plain)(s)); err != nil {
+		return err
+	}
+	if variables == nil {
+		return nil
+	}
+	var definitions []map[string]Variable
+	if resolveAlias(variables).Kind == yaml.MappingNode {
+		variables = resolveAlias(variables)
+		for i := 0; i+1 < len(variables.Content); i += 2 {
+			var variable Variable
+			if err := variables.Content[i+1].Decode(&variable); err != nil {
+				return err
+			}
+			definitions = append(definitions, map[string]Variable{variables.Content[i].Value: variable})
+		}
+	} else if err := variables.Decode(&definitions); err != nil {
+		return err
+	}
+	s.Variables = nil
+	for _, definition := range definitions {
+		values := make(map[string]string, len(definition))
+		for name, variable := range definition {
+			values[name] = variable.Value
+			if !variable.isPlain() {
+				if s.Definitions == nil {
+					s.Definitions = make(map[string]Variable)
+				}
+				s.Definitions[name] = variable
+			}
+		}
+		s.Variables = append(s.Variables, values)
+	}
+	return nil
+}
+
+// MarshalYAML writes the variables as a mapping by name, with their settings
+func (s Service) MarshalYAML() (interface{}, error) {
+	type plain Service
+	var node yaml.Node
+	if err := node.Encode(plain(s)); err != nil {
+		return nil, err
+	}
+	variables := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, values := range s.Variables {
+		names := make([]string, 0, len(values))
+		for name := range values {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			var value yaml.Node
+			if err := value.Encode(s.Variable(name)); err != nil {
+				return nil, err
+			}
+			variables.Content = append(variables.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: name}, &value)
+		}
+	}
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value != "variables" {
+			continue
+		}
+		if len(variables.Content) == 0 {
+			node.Content = append(node.Content[:i:i], node.Content[i+2:]...)
+		} else {
+			node.Content[i+1] = variables
+		}
+		break
+	}
+	return &node, nil
+}
+
+// Variable returns the definition of a variable, variables without settings only have their value set
+func (s Service) Variable(name string) Variable {
+	_, value := s.VariableValue(name)
+	variable, ok := s.Definitions[name]
+	if !ok {
+		return Variable{Value: value}
+	}
+	variable.Value = value
+	return variable
+}
+
+// Secret returns true if the value of a variable must not be shown, because it's marked as secret or comes from a
+// provider
+func (s Service) Secret(name string) bool {
+	return s.Definitions[name].Secret || s.Definitions[name].From != ""
+}
+
+// HasProviders returns true if the value of at least one variable comes from a provider
+func (s Service) HasProviders() bool {
+	for _, variable := range s.Definitions {
+		if variable.From != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Resolve returns a copy of the service with the values of all variables from providers filled in
+func (s Service) Resolve(resolver VariableResolver) (Service, error) {
+	resolved := s
+	resolved.Variables = make([]map[string]string, 0, len(s.Variables))
+	for _, variables := range s.Variables {
+		values := make(map[string]string, len(variables))
+		for name, value := range variables {
+			if variable := s.Definitions[name]; variable.From != "" {
+				var err error
+				if value, err = resolver.Resolve(variable); err != nil {
+					return s, fmt.Errorf("couldn't resolve variable %s: %w", name, err)
+				}
+			}
+			values[name] = value
+		}
+		resolved.Variables = append(resolved.Variables, values)
+	}
+	return resolved, nil
+}
+
+// minSecretLength is the length a secret value needs to be replaced in the output of services, shorter values would
+// replace too much of it
+const minSecretLength = 4
+
+// SecretValues returns the values of all secret variables of the service that are replaced wherever they appear in
+// output, values shorter than minSecretLength are left out
+func (s Service) SecretValues() []string {
+	var values []string
+	for _, variables := range s.Variables {
+		for name, value := range variables {
+			if s.Secret(name) && len(value) >= minSecretLength {
+				values = append(values, value)
+			}
+		}
+	}
+	return values
+}
@@ -0,0 +1,206 @@
+package history
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// FileName is the name of the history file in the state directory
+const FileName = "history.jsonl"
+
+const (
+	// EventStart is recorded when a service was started
+	EventStart = "start"
+	// EventStop is recorded when a service terminated
+	EventStop = "stop"
+)
+
+// Entry is a single line in the history file. Entries are only ever appended, a session consists of a start and a
+// stop entry of the same proc.
+type Entry struct {
+	Event       string    `json:"event"`
+	Time        time.Time `json:"time"`
+	User        string    `json:"user"`
+	Host        string    `json:"host,omitempty"`
+	Service     string    `json:"service"`
+	Environment string    `json:"environment"`
+	Port        uint      `json:"port,omitempty"`
+	Command     string    `json:"command"`
+	PID         int       `json:"pid"`
+	// ExitStatus and Error are only set on stop entries
+	ExitStatus int    `json:"exit_status,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Log is an append-only history file
+type Log struct {
+	path string
+	user string
+	host string
+	mu   sync.Mutex
+}
+
+// New returns a history log writing to a file in the given directory
+func New(dir string) *Log {
+	l := &Log{path: filepath.Join(dir, FileName)}
+	if u, err := user.Current(); err == nil {
+		l.user = u.Username
+	} else {
+		l.user = os.Getenv("USER")
+	}
+	if host, err := os.Hostname(); err == nil {
+		l.host = host
+	}
+	return l
+}
+
+// Append adds an entry to the history file. User, host and time are filled in if they aren't set.
+func (l *Log) Append(e Entry) error {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if e.User == "" {
+		e.User = l.user
+	}
+	if e.Host == "" {
+		e.Host = l.host
+	}
+	b, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// Read returns all entries of a history file. A missing file is not an error, there's just no history yet.
+func Read(dir string) ([]Entry, error) {
+	f, err := os.Open(filepath.Join(dir, FileName))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var e Entry
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			return nil, fmt.Errorf("invalid history entry on line %d: %w", line, err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, scanner.Err()
+}
+
+// Session is a started service, with the matching stop entry if it terminated already
+type Session struct {
+	Start Entry
+	// Stop is nil if the service is still running, or tbm was killed before it could record the stop
+	Stop *Entry
+}
+
+// End returns the time the session ended, or the zero time if it didn't end (yet)
+func (s Session) End() time.Time {
+	if s.Stop == nil {
+		return time.Time{}
+	}
+	return s.Stop.Time
+}
+
+// Duration returns how long the session lasted. Sessions that didn't end are counted until now.
+func (s Session) Duration() time.Duration {
+	if s.Stop == nil {
+		return time.Since(s.Start.Time)
+	}
+	return s.Stop.Time.Sub(s.Start.Time)
+}
+
+// Sessions matches start and stop entries of the same proc, sorted by start time
+func Sessions(entries []Entry) []Session {
+	type key struct {
+		host        string
+		service     string
+		environment string
+		pid         int
+	}
+	var sessions []*Session
+	open := make(map[key]*Session)
+	for i := range entries {
+		e := entries[i]
+		k := key{host: e.Host, service: e.Service, environment: e.Environment, pid: e.PID}
+		switch e.Event {
+		case EventStart:
+			s := &Session{Start: e}
+			open[k] = s
+			sessions = append(sessions, s)
+		case EventStop:
+			if s, ok := open[k]; ok {
+				s.Stop = &e
+				delete(open, k)
+			}
+		}
+	}
+
+	result := make([]Session, 0, len(sessions))
+	for _, s := range sessions {
+		result = append(result, *s)
+	}
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Start.Time.Before(result[j].Start.Time)
+	})
+	return result
+}
+
+// Filter selects sessions, empty fields match everything
+type Filter struct {
+	Environment string
+	Service     string
+	// Since and Until select sessions that overlap with the time range
+	Since time.Time
+	Until time.Time
+}
+
+// Match returns true if the session matches all fields of the filter
+func (f Filter) Match(s Session) bool {
+	if f.Environment != "" && s.Start.Environment != f.Environment {
+		return false
+	}
+	if f.Service != "" && s.Start.Service != f.Service {
+		return false
+	}
+	if !f.Until.IsZero() && s.Start.Time.After(f.Until) {
+		return false
+	}
+	if !f.Since.IsZero() && s.Stop != nil && s.Stop.Time.Before(f.Since) {
+		return false
+	}
+	return true
+}
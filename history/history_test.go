@@ -0,0 +1,62 @@
+package history
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLog_AppendAndSessions(t *testing.T) {
+	dir := t.TempDir()
+	l := New(dir)
+	start := time.Date(2023, time.January, 10, 10, 0, 0, 0, time.UTC)
+	entries := []Entry{
+		{Event: EventStart, Time: start, Service: "db", Environment: "prod", PID: 1},
+		{Event: EventStart, Time: start.Add(time.Minute), Service: "db", Environment: "stage", PID: 2},
+		{Event: EventStop, Time: start.Add(time.Hour), Service: "db", Environment: "prod", PID: 1, ExitStatus: 1},
+	}
+	for _, e := range entries {
+		if err := l.Append(e); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	read, err := Read(dir)
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	sessions := Sessions(read)
+	if len(sessions) != 2 {
+		t.Fatalf("Sessions() got %d sessions, want 2", len(sessions))
+	}
+	if sessions[0].Stop == nil || sessions[0].Duration() != time.Hour || sessions[0].Stop.ExitStatus != 1 {
+		t.Errorf("Sessions() first session = %+v, want stopped after an hour with exit status 1", sessions[0])
+	}
+	if sessions[1].Stop != nil {
+		t.Errorf("Sessions() second session = %+v, want still running", sessions[1])
+	}
+
+	tests := []struct {
+		name   string
+		filter Filter
+		want   int
+	}{
+		{name: "no filter", filter: Filter{}, want: 2},
+		{name: "environment", filter: Filter{Environment: "prod"}, want: 1},
+		{name: "unknown service", filter: Filter{Service: "cache"}, want: 0},
+		{name: "since after first session ended", filter: Filter{Since: start.Add(2 * time.Hour)}, want: 1},
+		{name: "until before sessions started", filter: Filter{Until: start.Add(-time.Hour)}, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := 0
+			for _, s := range sessions {
+				if tt.filter.Match(s) {
+					got++
+				}
+			}
+			if got != tt.want {
+				t.Errorf("Match() matched %d sessions, want %d", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package log
+
+import (
+	"fmt"
+	"hash/fnv"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// ColorDepth is the number of colors a terminal can show
+type ColorDepth int
+
+const (
+	// Colors16 are the 8 basic colors and their bright variants, every terminal supports them
+	Colors16 ColorDepth = iota
+	// Colors256 is the palette of xterm
+	Colors256
+	// Truecolor are 24 bit colors
+	Truecolor
+)
+
+// DetectColorDepth returns the color depth of the terminal, from $COLORTERM and $TERM
+func DetectColorDepth() ColorDepth {
+	switch strings.ToLower(os.Getenv("COLORTERM")) {
+	case "truecolor", "24bit":
+		return Truecolor
+	}
+	if os.Getenv("WT_SESSION") != "" {
+		// Windows Terminal supports 24 bit colors, but doesn't set COLORTERM
+		return Truecolor
+	}
+	if strings.Contains(os.Getenv("TERM"), "256color") {
+		return Colors256
+	}
+	return Colors16
+}
+
+// Color is the color of the lines of a service in the terminal, it's rendered with the color depth of the terminal.
+// The zero value is the default color.
+type Color struct {
+	// index is one of the 256 colors of xterm plus one, 0 if it isn't set
+	index int
+	rgb   [3]uint8
+	isRGB bool
+	// hash picks a color of the palette of the terminal, so it uses as many colors as the terminal has
+	hash     uint32
+	isHashed bool
+}
+
+// colorNames are the names of the basic colors, by their index
+var colorNames = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// ParseColor parses a color of the configuration file: the name of a basic color like "cyan" or "bright-cyan", one
+// of the 256 colors of xterm like "208", or a 24 bit color like "#ff8700"
+func ParseColor(s string) (Color, error) {
+	name := strings.ToLower(s)
+	for i, colorName := range colorNames {
+		if name == colorName {
+			return Color{index: i + 1}, nil
+		}
+		if name == "bright-"+colorName {
+			return Color{index: i + 9}, nil
+		}
+	}
+	if strings.HasPrefix(name, "#") {
+		v, err := strconv.ParseUint(name[1:], 16, 32)
+		if err != nil || len(name) != 7 {
+			return Color{}, fmt.Errorf("invalid color %q, 24 bit colors are written like #ff8700", s)
+		}
+		return Color{rgb: [3]uint8{uint8(v >> 16), uint8(v >> 8), uint8(v)}, isRGB: true}, nil
+	}
+	if index, err := strconv.Atoi(name); err == nil && index >= 0 && index < 256 {
+		return Color{index: index + 1}, nil
+	}
+	return Color{}, fmt.Errorf("invalid color %q, use a name like cyan or bright-cyan, a number up to 255 or #rrggbb", s)
+}
+
+// ServiceColor returns the color of a service without a configured color. It only depends on the name of the service,
+// so a service has the same color on every start, no matter which other services are started.
+func ServiceColor(name string) Color {
+	h := fnv.New32a()
+	//nolint
+	h.Write([]byte(name))
+	return Color{hash: h.Sum32(), isHashed: true}
+}
+
+// basicPalette are the colors of services on terminals with 16 colors, they are readable on dark and light backgrounds
+var basicPalette = []int{2, 6, 5, 3, 4, 1}
+
+// palette256 are the colors of services on terminals with 256 colors
+var palette256 = []int{26, 32, 37, 35, 70, 106, 136, 166, 160, 162, 127, 92, 62, 31, 72, 130, 168, 98, 29, 100}
+
+// SGR returns the parameters of the ANSI escape sequence of the color for a terminal of the given depth, colors the
+// terminal doesn't support are replaced with the closest one it has
+func (c Color) SGR(depth ColorDepth) string {
+	switch {
+	case c.isHashed && depth == Truecolor:
+		// Hues in steps of 15°, in two lightnesses
+		hue := float64(c.hash%24) * 15
+		lightness := 0.5
+		if (c.hash/24)%2 == 1 {
+			lightness = 0.62
+		}
+		return rgbSGR(hsl(hue, 0.65, lightness), depth)
+	case c.isHashed && depth == Colors256:
+		return indexSGR(palette256[c.hash%uint32(len(palette256))], depth)
+	case c.isHashed:
+		return indexSGR(basicPalette[c.hash%uint32(len(basicPalette))], depth)
+	case c.isRGB:
+		return rgbSGR(c.rgb, depth)
+	case c.index > 0:
+		return indexSGR(c.index-1, depth)
+	}
+	return indexSGR(basicPalette[0], depth)
+}
+
+// indexSGR returns the parameters of one of the 256 colors of xterm
+func indexSGR(index int, depth ColorDepth) string {
+	switch {
+	case index < 8:
+		return strconv.Itoa(30 + index)
+	case index < 16:
+		return strconv.Itoa(90 + index - 8)
+	case depth == Colors16:
+		return indexSGR(nearest(xtermRGB(index), 16), depth)
+	}
+	return fmt.Sprintf("38;5;%d", index)
+}
+
+// rgbSGR returns the parameters of a 24 bit color
+func rgbSGR(rgb [3]uint8, depth ColorDepth) string {
+	switch depth {
+	case Truecolor:
+		return fmt.Sprintf("38;2;%d;%d;%d", rgb[0], rgb[1], rgb[2])
+	case Colors256:
+		return indexSGR(nearest(rgb, 256), depth)
+	}
+	return indexSGR(nearest(rgb, 16), depth)
+}
+
+// nearest returns the index of the xterm color closest to rgb among the first n colors
+func nearest(rgb [3]uint8, n int) int {
+	best, bestDistance := 0, -1
+	for i := 0; i < n; i++ {
+		other := xtermRGB(i)
+		distance := 0
+		for j := range rgb {
+			d := int(rgb[j]) - int(other[j])
+			distance += d * d
+		}
+		if bestDistance < 0 || distance < bestDistance {
+			best, bestDistance = i, distance
+		}
+	}
+	return best
+}
+
+// basicRGB are the values of the 16 basic colors of xterm
+var basicRGB = [16][3]uint8{
+	{0, 0, 0}, {205, 0, 0}, {0, 205, 0}, {205, 205, 0}, {0, 0, 238}, {205, 0, 205}, {0, 205, 205}, {229, 229, 229},
+	{127, 127, 127}, {255, 0, 0}, {0, 255, 0}, {255, 255, 0}, {92, 92, 255}, {255, 0, 255}, {0, 255, 255}, {255, 255, 255},
+}
+
+// xtermRGB returns the value of one of the 256 colors of xterm: the basic colors, a 6x6x6 color cube and 24 grays
+func xtermRGB(index int) [3]uint8 {
+	if index < 16 {
+		return basicRGB[index]
+	}
+	if index >= 232 {
+		gray := uint8(8 + (index-232)*10)
+		return [3]uint8{gray, gray, gray}
+	}
+	level := func(v int) uint8 {
+		if v == 0 {
+			return 0
+		}
+		return uint8(55 + v*40)
+	}
+	index -= 16
+	return [3]uint8{level(index / 36), level(index / 6 % 6), level(index % 6)}
+}
+
+// hsl converts a color given as hue (0-360), saturation and lightness (0-1) to RGB
+func hsl(hue float64, saturation float64, lightness float64) [3]uint8 {
+	chroma := (1 - math.Abs(2*lightness-1)) * saturation
+	x := chroma * (1 - math.Abs(math.Mod(hue/60, 2)-1))
+	var r, g, b float64
+	switch {
+	case hue < 60:
+		r, g = chroma, x
+	case hue < 120:
+		r, g = x, chroma
+	case hue < 180:
+		g, b = chroma, x
+	case hue < 240:
+		g, b = x, chroma
+	case hue < 300:
+		r, b = x, chroma
+	default:
+		r, b = chroma, x
+	}
+	m := lightness - chroma/2
+	return [3]uint8{uint8((r + m) * 255), uint8((g + m) * 255), uint8((b + m) * 255)}
+}
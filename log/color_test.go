@@ -0,0 +1,55 @@
+package log
+
+import (
+	"testing"
+)
+
+func TestColor_SGR(t *testing.T) {
+	tests := []struct {
+		color   string
+		depth   ColorDepth
+		want    string
+		wantErr bool
+	}{
+		{color: "cyan", depth: Colors16, want: "36"},
+		{color: "Bright-Red", depth: Colors16, want: "91"},
+		{color: "208", depth: Colors256, want: "38;5;208"},
+		{color: "208", depth: Colors16, want: "33"},
+		{color: "#ff8700", depth: Truecolor, want: "38;2;255;135;0"},
+		{color: "#ff8700", depth: Colors256, want: "38;5;208"},
+		{color: "#00cdcd", depth: Colors16, want: "36"},
+		{color: "256", wantErr: true},
+		{color: "#ff87", wantErr: true},
+		{color: "orange", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.color, func(t *testing.T) {
+			c, err := ParseColor(tt.color)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseColor() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got := c.SGR(tt.depth); !tt.wantErr && got != tt.want {
+				t.Errorf("SGR() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestServiceColor(t *testing.T) {
+	for _, depth := range []ColorDepth{Colors16, Colors256, Truecolor} {
+		if a, b := ServiceColor("db").SGR(depth), ServiceColor("db").SGR(depth); a != b {
+			t.Errorf("colors of the same service differ: %q and %q", a, b)
+		}
+	}
+	// More services than basic colors get distinct colors on terminals with more colors
+	seen := make(map[string]string)
+	for _, name := range []string{"db", "cache", "api", "web", "worker", "proxy", "queue", "search"} {
+		seen[ServiceColor(name).SGR(Truecolor)] = name
+	}
+	if len(seen) < 7 {
+		t.Errorf("8 services only got %d colors", len(seen))
+	}
+	if got := (Color{}).SGR(Colors16); got != "32" {
+		t.Errorf("default color = %q, want 32", got)
+	}
+}
@@ -3,35 +3,101 @@ package log
 import (
 	"bytes"
 	"fmt"
-	"github.com/mattn/go-colorable"
+	"github.com/dewey/tbm/redact"
 	"io"
+	"os"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 type Clogger struct {
-	idx               int
+	color             Color
 	name              string
 	environment       string
 	maxProcNameLength int
-	writes            chan []byte
+	protected         bool
+	lineHook          func(line string) Level
+	redactor          atomic.Pointer[redact.Redactor]
+	sink              Sink
+	writes            chan write
 	done              chan struct{}
-	timeout           time.Duration // how long to wait before printing partial lines
-	buffers           buffers       // partial lines awaiting printing
+	pid               atomic.Int64
+	timeout           time.Duration    // how long to wait before printing partial lines
+	buffers           [streams]buffers // partial lines awaiting printing, per stream
 }
 
-var colors = []int{
-	32, // green
-	36, // cyan
-	35, // magenta
-	33, // yellow
-	34, // blue
-	31, // red
+// Stream identifies where a line of output of a service came from
+type Stream int
+
+const (
+	// StreamStdout is the standard output of a service
+	StreamStdout Stream = iota
+	// StreamStderr is the standard error of a service
+	StreamStderr
+	// streams is the number of streams
+	streams
+)
+
+func (s Stream) String() string {
+	if s == StreamStderr {
+		return "stderr"
+	}
+	return "stdout"
 }
-var mutex = new(sync.Mutex)
 
-var out = colorable.NewColorableStdout()
+// write is a chunk of output passed to the writer go routine
+type write struct {
+	p      []byte
+	stream Stream
+	// own is set for messages of tbm itself, they are always complete lines and skip the line hook
+	own bool
+	// event is set for lifecycle events, p is empty then
+	event *Event
+}
+
+// Level is the severity of a line of output
+type Level int
+
+const (
+	// LevelInfo is the default for every line
+	LevelInfo Level = iota
+	// LevelWarning lines are highlighted as warnings
+	LevelWarning
+	// LevelError lines are highlighted as errors
+	LevelError
+)
+
+func (l Level) String() string {
+	switch l {
+	case LevelWarning:
+		return "warning"
+	case LevelError:
+		return "error"
+	}
+	return "info"
+}
+
+// Event types of the lifecycle of a service
+const (
+	EventStarting   = "starting"
+	EventReady      = "ready"
+	EventRestarting = "restarting"
+	EventStopping   = "stopping"
+	EventTerminated = "terminated"
+	EventFailed     = "failed"
+)
+
+// Event is a lifecycle event of a service, like it being started or terminated. Text sinks only print the message,
+// events without message are only visible in structured sinks.
+type Event struct {
+	Type    string
+	Message string
+	Port    uint
+	// ExitCode is set for terminated and failed services
+	ExitCode *int
+}
 
 type buffers [][]byte
 
@@ -62,18 +128,41 @@ func (v *buffers) WriteTo(w io.Writer) (n int64, err error) {
 
 // write any stored buffers, plus the given line, then empty out
 // the buffers.
-func (l *Clogger) writeBuffers(line []byte) {
-	mutex.Lock()
-	fmt.Fprintf(out, "\x1b[%dm", colors[l.idx])
-	now := time.Now().Format("15:04:05")
-	// Pretty print the environment, we remove it from the proc name again. There it only exists so services with the same name across environments are still unique.
-	fmt.Fprintf(out, "%s %*s (%s) | ", now, l.maxProcNameLength, strings.Replace(l.name, "-"+l.environment, "", -1), l.environment)
-	fmt.Fprintf(out, "\x1b[m")
-	l.buffers = append(l.buffers, line)
+func (l *Clogger) writeBuffers(stream Stream, line []byte) {
+	l.buffers[stream] = append(l.buffers[stream], line)
+	var b bytes.Buffer
 	//nolint
-	l.buffers.WriteTo(out)
-	l.buffers = l.buffers[0:0]
-	mutex.Unlock()
+	l.buffers[stream].WriteTo(&b)
+	l.buffers[stream] = l.buffers[stream][0:0]
+	text := strings.TrimRight(b.String(), "\r\n")
+	level := LevelInfo
+	if l.lineHook != nil {
+		level = l.lineHook(text)
+	}
+	l.print(Record{Kind: KindLine, Stream: stream, Level: level, Message: text})
+}
+
+// print fills in the details of the logger, redacts secrets and passes the record to the sink
+func (l *Clogger) print(r Record) {
+	r.Time = time.Now()
+	r.Message = l.redactor.Load().String(r.Message)
+	if r.Event != nil {
+		event := *r.Event
+		event.Message = r.Message
+		r.Event = &event
+	}
+	r.Service = l.name
+	r.Environment = l.environment
+	if l.environment != "" {
+		// Pretty print the environment, we remove it from the proc name again. There it only exists so services with the same name across environments are still unique.
+		r.Service = strings.Replace(l.name, "-"+l.environment, "", -1)
+	}
+	r.NameWidth = l.maxProcNameLength
+	r.Color = l.color
+	r.Protected = l.protected
+	r.PID = int(l.pid.Load())
+	//nolint
+	l.sink.Write(r)
 }
 
 // bundle writes into lines, waiting briefly for completion of lines
@@ -83,12 +172,22 @@ func (l *Clogger) writeLines() {
 		select {
 		case w, ok := <-l.writes:
 			if !ok {
-				if len(l.buffers) > 0 {
-					l.writeBuffers([]byte("\n"))
-				}
+				l.flushBuffers()
 				return
 			}
-			buf := bytes.NewBuffer(w)
+			if w.event != nil {
+				l.print(Record{Kind: KindEvent, Message: w.event.Message, Event: w.event})
+				l.done <- struct{}{}
+				continue
+			}
+			if w.own {
+				for _, line := range strings.Split(strings.TrimRight(string(w.p), "\n"), "\n") {
+					l.print(Record{Kind: KindMessage, Message: line})
+				}
+				l.done <- struct{}{}
+				continue
+			}
+			buf := bytes.NewBuffer(w.p)
 			for {
 				line, err := buf.ReadBytes('\n')
 				if len(line) > 0 {
@@ -96,12 +195,12 @@ func (l *Clogger) writeLines() {
 						// any text followed by a newline should flush
 						// existing buffers. a bare newline should flush
 						// existing buffers, but only if there are any.
-						if len(line) != 1 || len(l.buffers) > 0 {
-							l.writeBuffers(line)
+						if len(line) != 1 || len(l.buffers[w.stream]) > 0 {
+							l.writeBuffers(w.stream, line)
 						}
 						tick = nil
 					} else {
-						l.buffers = append(l.buffers, line)
+						l.buffers[w.stream] = append(l.buffers[w.stream], line)
 						tick = time.After(l.timeout)
 					}
 				}
@@ -111,27 +210,142 @@ func (l *Clogger) writeLines() {
 			}
 			l.done <- struct{}{}
 		case <-tick:
-			if len(l.buffers) > 0 {
-				l.writeBuffers([]byte("\n"))
-			}
+			l.flushBuffers()
 			tick = nil
 		}
 	}
 
 }
 
+// flushBuffers prints the partial lines of all streams
+func (l *Clogger) flushBuffers() {
+	for stream := range l.buffers {
+		if len(l.buffers[stream]) > 0 {
+			l.writeBuffers(Stream(stream), []byte("\n"))
+		}
+	}
+}
+
 // write handler of logger.
 func (l *Clogger) Write(p []byte) (int, error) {
-	l.writes <- p
+	l.writes <- write{p: p}
+	<-l.done
+	return len(p), nil
+}
+
+// Stderr returns a writer for the standard error of the service. Its lines are marked in the output.
+func (l *Clogger) Stderr() io.Writer {
+	return stderrWriter{l: l}
+}
+
+// stderrWriter passes writes to the logger, marked as standard error
+type stderrWriter struct {
+	l *Clogger
+}
+
+func (w stderrWriter) Write(p []byte) (int, error) {
+	w.l.writes <- write{p: p, stream: StreamStderr}
+	<-w.l.done
+	return len(p), nil
+}
+
+// Printf writes a message of tbm itself, like a service being started. Messages don't pass the line hook.
+func (l *Clogger) Printf(format string, a ...interface{}) {
+	l.writes <- write{p: []byte(fmt.Sprintf(format, a...)), own: true}
 	<-l.done
+}
+
+// Event writes a lifecycle event of the service
+func (l *Clogger) Event(e Event) {
+	l.writes <- write{event: &e}
+	<-l.done
+}
+
+// SetPID sets the process id of the service that is included in records, 0 if it isn't running
+func (l *Clogger) SetPID(pid int) {
+	l.pid.Store(int64(pid))
+}
+
+// SetRedactor replaces the redactor, for secrets that are only known once the service is started
+func (l *Clogger) SetRedactor(r *redact.Redactor) {
+	l.redactor.Store(r)
+}
+
+// Prefixed returns a writer for the output of commands run by tbm itself, like hooks. Every line is prefixed and
+// written as a message of tbm, so it doesn't pass the line hook. Close flushes a trailing partial line.
+func (l *Clogger) Prefixed(prefix string) io.WriteCloser {
+	return &prefixWriter{l: l, prefix: prefix}
+}
+
+// prefixWriter splits output into lines and writes them as messages of the logger
+type prefixWriter struct {
+	l      *Clogger
+	prefix string
+	mu     sync.Mutex
+	buf    []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.buf = append(w.buf, p...)
+	for {
+		i := bytes.IndexByte(w.buf, '\n')
+		if i < 0 {
+			break
+		}
+		w.l.Printf("%s%s\n", w.prefix, strings.TrimRight(string(w.buf[:i]), "\r"))
+		w.buf = w.buf[i+1:]
+	}
 	return len(p), nil
 }
 
+func (w *prefixWriter) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if len(w.buf) > 0 {
+		w.l.Printf("%s%s\n", w.prefix, w.buf)
+		w.buf = nil
+	}
+	return nil
+}
+
+// Options are used to create a new console logger
+type Options struct {
+	Name              string
+	Environment       string
+	Color             Color
+	MaxProcNameLength int
+	// Protected loggers always use the warning color instead of Color and print a banner when they are created
+	Protected bool
+	// LineHook is called with every complete line of output and returns the level it's printed with. It's called from
+	// the writer go routine, so it must not block or write to the logger.
+	LineHook func(line string) Level
+	// Redactor replaces secrets in everything the logger prints, the line hook still gets the original line
+	Redactor *redact.Redactor
+	// Sink receives all records of the logger, the default sink for the terminal is used if it's nil
+	Sink Sink
+}
+
+var (
+	defaultSink     Sink
+	defaultSinkOnce sync.Once
+)
+
 // New initializes a new console logger instance
-func New(name string, environment string, colorIndex int, maxProcNameLength int) *Clogger {
-	mutex.Lock()
-	defer mutex.Unlock()
-	l := &Clogger{idx: colorIndex, name: name, environment: environment, maxProcNameLength: maxProcNameLength, writes: make(chan []byte), done: make(chan struct{}), timeout: 2 * time.Millisecond}
+func New(opts Options) *Clogger {
+	sink := opts.Sink
+	if sink == nil {
+		defaultSinkOnce.Do(func() {
+			defaultSink = NewSink(FormatText, os.Stdout)
+		})
+		sink = defaultSink
+	}
+	l := &Clogger{color: opts.Color, name: opts.Name, environment: opts.Environment, maxProcNameLength: opts.MaxProcNameLength, protected: opts.Protected, lineHook: opts.LineHook, sink: sink, writes: make(chan write), done: make(chan struct{}), timeout: 2 * time.Millisecond}
+	l.redactor.Store(opts.Redactor)
+	if l.protected {
+		l.print(Record{Kind: KindBanner, Message: fmt.Sprintf("!!! %s runs in the protected environment %s !!!", strings.Replace(l.name, "-"+l.environment, "", -1), l.environment)})
+	}
 	go l.writeLines()
 	return l
 }
@@ -0,0 +1,72 @@
+package log
+
+import "sync"
+
+// RingSink keeps the most recent records in memory. It's used to show recent output of running services without log
+// files, and to follow their output live.
+type RingSink struct {
+	mu          sync.Mutex
+	records     []Record
+	next        int
+	full        bool
+	subscribers map[chan<- Record]struct{}
+}
+
+// NewRingSink returns a sink keeping the last size records
+func NewRingSink(size int) *RingSink {
+	if size < 1 {
+		size = 1
+	}
+	return &RingSink{records: make([]Record, size), subscribers: make(map[chan<- Record]struct{})}
+}
+
+// Write stores the record, overwriting the oldest one if the buffer is full, and passes it to all subscribers.
+// Subscribers that don't keep up miss records instead of blocking the service. Like in text sinks, banners and events
+// without message are skipped.
+func (s *RingSink) Write(r Record) error {
+	if r.Kind == KindBanner || (r.Kind == KindEvent && r.Message == "") {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records[s.next] = r
+	s.next = (s.next + 1) % len(s.records)
+	if s.next == 0 {
+		s.full = true
+	}
+	for ch := range s.subscribers {
+		select {
+		case ch <- r:
+		default:
+		}
+	}
+	return nil
+}
+
+// Records returns the stored records, oldest first
+func (s *RingSink) Records() []Record {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.snapshot()
+}
+
+// snapshot copies the stored records, the caller must hold the lock
+func (s *RingSink) snapshot() []Record {
+	if !s.full {
+		return append([]Record(nil), s.records[:s.next]...)
+	}
+	return append(append([]Record(nil), s.records[s.next:]...), s.records[:s.next]...)
+}
+
+// Subscribe returns the stored records and sends every following record to ch until cancel is called. No record is
+// missed or duplicated between the two.
+func (s *RingSink) Subscribe(ch chan<- Record) (records []Record, cancel func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.subscribers[ch] = struct{}{}
+	return s.snapshot(), func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		delete(s.subscribers, ch)
+	}
+}
@@ -0,0 +1,58 @@
+package log
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRingSink(t *testing.T) {
+	tests := []struct {
+		name   string
+		size   int
+		writes []string
+		want   []string
+	}{
+		{name: "empty", size: 3, want: nil},
+		{name: "not full", size: 3, writes: []string{"a", "b"}, want: []string{"a", "b"}},
+		{name: "full", size: 3, writes: []string{"a", "b", "c"}, want: []string{"a", "b", "c"}},
+		{name: "overwritten", size: 3, writes: []string{"a", "b", "c", "d", "e"}, want: []string{"c", "d", "e"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewRingSink(tt.size)
+			for _, message := range tt.writes {
+				//nolint
+				s.Write(Record{Kind: KindLine, Message: message})
+			}
+			var got []string
+			for _, r := range s.Records() {
+				got = append(got, r.Message)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Records() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRingSink_Subscribe(t *testing.T) {
+	s := NewRingSink(10)
+	//nolint
+	s.Write(Record{Kind: KindLine, Message: "before"})
+	ch := make(chan Record, 10)
+	records, cancel := s.Subscribe(ch)
+	if len(records) != 1 || records[0].Message != "before" {
+		t.Fatalf("Subscribe() records = %v, want the record written before", records)
+	}
+	//nolint
+	s.Write(Record{Kind: KindLine, Message: "after"})
+	cancel()
+	//nolint
+	s.Write(Record{Kind: KindLine, Message: "cancelled"})
+	if got := len(ch); got != 1 {
+		t.Fatalf("subscriber received %d records, want 1", got)
+	}
+	if r := <-ch; r.Message != "after" {
+		t.Errorf("subscriber received %q, want %q", r.Message, "after")
+	}
+}
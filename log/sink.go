@@ -0,0 +1,417 @@
+package log
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/mattn/go-colorable"
+	"github.com/mattn/go-isatty"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Kinds of records
+const (
+	// KindLine is a line of output of a service
+	KindLine = "line"
+	// KindMessage is a message of tbm itself, or the output of a hook
+	KindMessage = "message"
+	// KindEvent is a lifecycle event of a service
+	KindEvent = "event"
+	// KindBanner is printed when the logger of a protected service is created
+	KindBanner = "banner"
+)
+
+// Record is a single line passed to a sink, together with everything needed to render it
+type Record struct {
+	Time        time.Time
+	Kind        string
+	Service     string
+	Environment string
+	// NameWidth is the width the service name is padded to, so the output is aligned
+	NameWidth int
+	Color     Color
+	Protected bool
+	PID       int
+	Stream    Stream
+	Level     Level
+	Message   string
+	// Event is only set for records of KindEvent
+	Event *Event
+}
+
+// Sink receives the records of loggers. A sink is usually shared by all loggers, implementations have to be safe for
+// concurrent use.
+type Sink interface {
+	Write(r Record) error
+}
+
+// Format is the output format of the logs shown in the terminal
+type Format string
+
+const (
+	// FormatText prints lines prefixed with the time and the service
+	FormatText Format = "text"
+	// FormatJSON prints every line as a JSON object
+	FormatJSON Format = "json"
+)
+
+// ParseFormat returns the format with the given name
+func ParseFormat(name string) (Format, error) {
+	switch f := Format(name); f {
+	case FormatText, FormatJSON:
+		return f, nil
+	}
+	return "", fmt.Errorf("unknown log format %q, use %s or %s", name, FormatText, FormatJSON)
+}
+
+// NewSink returns the sink for the given format writing to f, usually os.Stdout. Text is colored if f is a terminal
+// and NO_COLOR isn't set, otherwise it's written without ANSI codes.
+func NewSink(format Format, f *os.File) Sink {
+	if format == FormatJSON {
+		return NewJSONSink(f)
+	}
+	if os.Getenv("NO_COLOR") != "" || !(isatty.IsTerminal(f.Fd()) || isatty.IsCygwinTerminal(f.Fd())) {
+		return NewPlainSink(f)
+	}
+	sink := NewTerminalSink(colorable.NewColorable(f))
+	sink.Depth = DetectColorDepth()
+	return sink
+}
+
+// protectedColor is used for all services of protected environments, bold white on red
+const protectedColor = "1;97;41"
+
+var levelColors = map[Level]string{
+	LevelWarning: "33",
+	LevelError:   "1;31",
+}
+
+// TerminalSink prints colored lines, every service has its own color
+type TerminalSink struct {
+	// Depth is the number of colors of the terminal, only the 16 basic colors are used by default
+	Depth ColorDepth
+	mu    sync.Mutex
+	out   io.Writer
+}
+
+// NewTerminalSink returns a sink writing lines with ANSI colors
+func NewTerminalSink(w io.Writer) *TerminalSink {
+	return &TerminalSink{out: w}
+}
+
+// color returns the ANSI color code used for the line prefix
+func (s *TerminalSink) color(r Record) string {
+	if r.Protected {
+		return protectedColor
+	}
+	return r.Color.SGR(s.Depth)
+}
+
+// Write prints a single line with the prefix of the service. Lines written to stderr are marked with a red "!"
+// instead of the "|" separator.
+func (s *TerminalSink) Write(r Record) error {
+	if r.Kind == KindEvent && r.Message == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if r.Kind == KindBanner {
+		_, err := fmt.Fprintf(s.out, "\x1b[%sm%s\x1b[m\n", s.color(r), r.Message)
+		return err
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "\x1b[%sm%s ", s.color(r), prefix(r, "15:04:05"))
+	if r.Stream == StreamStderr {
+		b.WriteString("\x1b[m\x1b[1;31m! \x1b[m")
+	} else {
+		b.WriteString("| \x1b[m")
+	}
+	if c, ok := levelColors[r.Level]; ok {
+		fmt.Fprintf(&b, "\x1b[%sm%s\x1b[m\n", c, r.Message)
+	} else {
+		fmt.Fprintf(&b, "%s\n", r.Message)
+	}
+	_, err := io.WriteString(s.out, b.String())
+	return err
+}
+
+// prefix returns the time, service and environment a line starts with
+func prefix(r Record, layout string) string {
+	if r.Environment == "" {
+		return fmt.Sprintf("%s %*s", r.Time.Format(layout), r.NameWidth, r.Service)
+	}
+	return fmt.Sprintf("%s %*s (%s)", r.Time.Format(layout), r.NameWidth, r.Service, r.Environment)
+}
+
+// plain renders a record without any ANSI codes. Lines written to stderr use "!" instead of "|", highlighted lines
+// are prefixed with their level.
+func plain(r Record, layout string) string {
+	if r.Kind == KindBanner {
+		return r.Message + "\n"
+	}
+	separator := "|"
+	if r.Stream == StreamStderr {
+		separator = "!"
+	}
+	message := r.Message
+	if r.Level != LevelInfo {
+		message = fmt.Sprintf("[%s] %s", r.Level, message)
+	}
+	return fmt.Sprintf("%s %s %s\n", prefix(r, layout), separator, message)
+}
+
+// FormatLine renders a record like a line of a log file, with the full date and without ANSI codes
+func FormatLine(r Record) string {
+	return plain(r, FileTimeLayout)
+}
+
+// PlainSink prints lines without colors, for output that isn't a terminal or if NO_COLOR is set
+type PlainSink struct {
+	mu  sync.Mutex
+	out io.Writer
+}
+
+// NewPlainSink returns a sink writing lines without ANSI codes
+func NewPlainSink(w io.Writer) *PlainSink {
+	return &PlainSink{out: w}
+}
+
+// Write prints a record as a plain line
+func (s *PlainSink) Write(r Record) error {
+	if r.Kind == KindEvent && r.Message == "" {
+		return nil
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, err := io.WriteString(s.out, plain(r, "15:04:05"))
+	return err
+}
+
+// jsonRecord is how a record is written by the JSON sink
+type jsonRecord struct {
+	Time        string `json:"time"`
+	Type        string `json:"type"`
+	Service     string `json:"service"`
+	Environment string `json:"environment,omitempty"`
+	Protected   bool   `json:"protected,omitempty"`
+	Stream      string `json:"stream,omitempty"`
+	PID         int    `json:"pid,omitempty"`
+	Level       string `json:"level,omitempty"`
+	Event       string `json:"event,omitempty"`
+	Port        uint   `json:"port,omitempty"`
+	ExitCode    *int   `json:"exit_code,omitempty"`
+	Message     string `json:"message"`
+}
+
+// JSONSink writes every record as a JSON object on its own line
+type JSONSink struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// NewJSONSink returns a sink writing JSON lines
+func NewJSONSink(w io.Writer) *JSONSink {
+	return &JSONSink{enc: json.NewEncoder(w)}
+}
+
+// Write encodes a record as JSON, banners are skipped as every record has the protected flag already
+func (s *JSONSink) Write(r Record) error {
+	if r.Kind == KindBanner {
+		return nil
+	}
+	j := jsonRecord{
+		Time:        r.Time.Format(time.RFC3339Nano),
+		Type:        r.Kind,
+		Service:     r.Service,
+		Environment: r.Environment,
+		Protected:   r.Protected,
+		PID:         r.PID,
+		Message:     r.Message,
+	}
+	if r.Kind == KindLine {
+		j.Stream = r.Stream.String()
+		j.Level = r.Level.String()
+	}
+	if r.Event != nil {
+		j.Event = r.Event.Type
+		j.Port = r.Event.Port
+		j.ExitCode = r.Event.ExitCode
+		if j.Message == "" {
+			j.Message = fmt.Sprintf("%s %s", j.Service, j.Event)
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.enc.Encode(j)
+}
+
+// multiSink writes every record to several sinks
+type multiSink []Sink
+
+// Multi returns a sink that fans out every record to all given sinks
+func Multi(sinks ...Sink) Sink {
+	return multiSink(sinks)
+}
+
+// Write passes the record to all sinks, and returns the first error
+func (m multiSink) Write(r Record) error {
+	var err error
+	for _, s := range m {
+		if sinkErr := s.Write(r); sinkErr != nil && err == nil {
+			err = sinkErr
+		}
+	}
+	return err
+}
+
+// quietSink drops the standard output of services
+type quietSink struct {
+	Sink
+}
+
+// QuietStdout returns a sink that drops lines services wrote to their standard output
+func QuietStdout(s Sink) Sink {
+	return quietSink{Sink: s}
+}
+
+// Write passes everything but the standard output of services to the wrapped sink
+func (s quietSink) Write(r Record) error {
+	if r.Kind == KindLine && r.Stream == StreamStdout {
+		return nil
+	}
+	return s.Sink.Write(r)
+}
+
+// RotatingFileSink writes plain lines with the full date to a file. Once the file reaches its max size it's rotated:
+// the current file is renamed with the suffix .1, existing rotated files are shifted and only MaxFiles of them are kept.
+type RotatingFileSink struct {
+	mu       sync.Mutex
+	path     string
+	maxSize  int64
+	maxFiles int
+	f        *os.File
+	size     int64
+}
+
+// FilePath returns the path of the log file of a service in the given directory
+func FilePath(dir string, service string, environment string) string {
+	if environment == "" {
+		environment = "default"
+	}
+	return filepath.Join(dir, environment, service+".log")
+}
+
+// FileTimeLayout is the layout of the time lines in log files start with
+const FileTimeLayout = "2006-01-02T15:04:05.000Z07:00"
+
+// NewRotatingFileSink opens (or creates) the file at path. A maxSize of 0 disables rotation.
+func NewRotatingFileSink(path string, maxSize int64, maxFiles int) (*RotatingFileSink, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return nil, err
+	}
+	s := &RotatingFileSink{path: path, maxSize: maxSize, maxFiles: maxFiles}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// open opens the log file for appending and picks up its current size
+func (s *RotatingFileSink) open() error {
+	f, err := os.OpenFile(s.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	s.size = info.Size()
+	return nil
+}
+
+// rotate shifts the rotated files by one, moves the current file to .1 and opens a new one
+func (s *RotatingFileSink) rotate() error {
+	if err := s.f.Close(); err != nil {
+		return err
+	}
+	if s.maxFiles > 0 {
+		//nolint
+		os.Remove(fmt.Sprintf("%s.%d", s.path, s.maxFiles))
+		for i := s.maxFiles - 1; i >= 1; i-- {
+			//nolint
+			os.Rename(fmt.Sprintf("%s.%d", s.path, i), fmt.Sprintf("%s.%d", s.path, i+1))
+		}
+		if err := os.Rename(s.path, s.path+".1"); err != nil {
+			return err
+		}
+	} else if err := os.Remove(s.path); err != nil {
+		return err
+	}
+	return s.open()
+}
+
+// Write appends a plain line to the file, events without message are skipped
+func (s *RotatingFileSink) Write(r Record) error {
+	if r.Kind == KindEvent && r.Message == "" {
+		return nil
+	}
+	line := FormatLine(r)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return os.ErrClosed
+	}
+	if s.maxSize > 0 && s.size > 0 && s.size+int64(len(line)) > s.maxSize {
+		if err := s.rotate(); err != nil {
+			return err
+		}
+	}
+	n, err := io.WriteString(s.f, line)
+	s.size += int64(n)
+	return err
+}
+
+// Close closes the log file
+func (s *RotatingFileSink) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.f == nil {
+		return nil
+	}
+	err := s.f.Close()
+	s.f = nil
+	return err
+}
+
+// RotatedFiles returns the existing files of a rotated log file, oldest first and the current file last
+func RotatedFiles(path string) []string {
+	var files []string
+	for i := 1; ; i++ {
+		rotated := fmt.Sprintf("%s.%d", path, i)
+		if _, err := os.Stat(rotated); err != nil {
+			break
+		}
+		files = append([]string{rotated}, files...)
+	}
+	if _, err := os.Stat(path); err == nil {
+		files = append(files, path)
+	}
+	return files
+}
+
+// LineTime parses the time a line of a log file starts with
+func LineTime(line string) (time.Time, bool) {
+	end := strings.IndexByte(line, ' ')
+	if end < 0 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(FileTimeLayout, line[:end])
+	return t, err == nil
+}
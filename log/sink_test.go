@@ -0,0 +1,181 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"github.com/dewey/tbm/redact"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recordingSink keeps all records in memory
+type recordingSink struct {
+	mu      sync.Mutex
+	records []Record
+}
+
+func (s *recordingSink) Write(r Record) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.records = append(s.records, r)
+	return nil
+}
+
+func TestClogger_Records(t *testing.T) {
+	sink := &recordingSink{}
+	l := New(Options{
+		Name:        "db-prod",
+		Environment: "prod",
+		LineHook: func(line string) Level {
+			if strings.Contains(line, "refused") {
+				return LevelError
+			}
+			return LevelInfo
+		},
+		Redactor: redact.New("hunter2"),
+		Sink:     QuietStdout(sink),
+	})
+	fmt.Fprint(l, "hidden\n")
+	fmt.Fprint(l.Stderr(), "connection refused for hunter2\n")
+	l.Printf("Starting db\n")
+	l.Event(Event{Type: EventTerminated})
+
+	want := []Record{
+		{Kind: KindLine, Stream: StreamStderr, Level: LevelError, Message: "connection refused for [redacted]"},
+		{Kind: KindMessage, Message: "Starting db"},
+		{Kind: KindEvent},
+	}
+	if len(sink.records) != len(want) {
+		t.Fatalf("got %d records, want %d: %+v", len(sink.records), len(want), sink.records)
+	}
+	for i, r := range sink.records {
+		if r.Service != "db" || r.Environment != "prod" {
+			t.Errorf("record %d has service %q (%q), want db (prod)", i, r.Service, r.Environment)
+		}
+		if r.Kind != want[i].Kind || r.Stream != want[i].Stream || r.Level != want[i].Level || r.Message != want[i].Message {
+			t.Errorf("record %d = %+v, want %+v", i, r, want[i])
+		}
+	}
+}
+
+func TestSinks_Format(t *testing.T) {
+	now := time.Date(2023, time.January, 10, 10, 0, 0, 0, time.UTC)
+	exitCode := 1
+	tests := []struct {
+		name   string
+		sink   func(*bytes.Buffer) Sink
+		record Record
+		want   string
+	}{
+		{
+			name:   "plain stdout",
+			sink:   func(b *bytes.Buffer) Sink { return NewPlainSink(b) },
+			record: Record{Time: now, Kind: KindLine, Service: "db", Environment: "prod", NameWidth: 3, Message: "hello"},
+			want:   "10:00:00  db (prod) | hello\n",
+		},
+		{
+			name:   "plain stderr with level",
+			sink:   func(b *bytes.Buffer) Sink { return NewPlainSink(b) },
+			record: Record{Time: now, Kind: KindLine, Service: "db", Environment: "prod", Stream: StreamStderr, Level: LevelError, Message: "boom"},
+			want:   "10:00:00 db (prod) ! [error] boom\n",
+		},
+		{
+			name:   "plain event without message",
+			sink:   func(b *bytes.Buffer) Sink { return NewPlainSink(b) },
+			record: Record{Time: now, Kind: KindEvent, Service: "db", Event: &Event{Type: EventReady}},
+			want:   "",
+		},
+		{
+			name:   "terminal",
+			sink:   func(b *bytes.Buffer) Sink { return NewTerminalSink(b) },
+			record: Record{Time: now, Kind: KindLine, Service: "db", Environment: "prod", Color: ServiceColor("db"), Message: "hello"},
+			want:   "\x1b[36m10:00:00 db (prod) | \x1b[mhello\n",
+		},
+		{
+			name:   "json event",
+			sink:   func(b *bytes.Buffer) Sink { return NewJSONSink(b) },
+			record: Record{Time: now, Kind: KindEvent, Service: "db", Environment: "prod", PID: 42, Event: &Event{Type: EventTerminated, ExitCode: &exitCode}},
+			want:   `{"time":"2023-01-10T10:00:00Z","type":"event","service":"db","environment":"prod","pid":42,"event":"terminated","exit_code":1,"message":"db terminated"}` + "\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if err := tt.sink(&b).Write(tt.record); err != nil {
+				t.Fatalf("Write() error = %v", err)
+			}
+			if got := b.String(); got != tt.want {
+				t.Errorf("Write() wrote %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMulti(t *testing.T) {
+	var plainOut, jsonOut bytes.Buffer
+	s := Multi(NewPlainSink(&plainOut), NewJSONSink(&jsonOut))
+	if err := s.Write(Record{Kind: KindLine, Service: "db", Message: "hello"}); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	if !strings.Contains(plainOut.String(), "hello") {
+		t.Errorf("plain sink got %q", plainOut.String())
+	}
+	var j map[string]interface{}
+	if err := json.Unmarshal(jsonOut.Bytes(), &j); err != nil || j["message"] != "hello" {
+		t.Errorf("json sink got %q", jsonOut.String())
+	}
+}
+
+func TestRotatingFileSink(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "db.log")
+	s, err := NewRotatingFileSink(path, 100, 2)
+	if err != nil {
+		t.Fatalf("NewRotatingFileSink() error = %v", err)
+	}
+	for i := 0; i < 10; i++ {
+		if err := s.Write(Record{Kind: KindLine, Service: "db", Message: fmt.Sprintf("line %d", i)}); err != nil {
+			t.Fatalf("Write() error = %v", err)
+		}
+	}
+	if err := s.Close(); err != nil {
+		t.Fatalf("Close() error = %v", err)
+	}
+
+	for _, name := range []string{path, path + ".1", path + ".2"} {
+		info, err := os.Stat(name)
+		if err != nil {
+			t.Fatalf("expected %s to exist: %v", name, err)
+		}
+		if info.Size() > 100 {
+			t.Errorf("%s has %d bytes, max size is 100", name, info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("expected only 2 rotated files to be kept")
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), "line 9") {
+		t.Errorf("current file doesn't contain the last line: %q", b)
+	}
+
+	files := RotatedFiles(path)
+	if want := []string{path + ".2", path + ".1", path}; !reflect.DeepEqual(files, want) {
+		t.Errorf("RotatedFiles() = %v, want %v", files, want)
+	}
+	first, err := os.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := LineTime(string(first)); !ok {
+		t.Errorf("LineTime() couldn't parse %q", first)
+	}
+}
@@ -0,0 +1,255 @@
+package proc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/dewey/tbm/log"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrNotRunning is returned by the control client if no tbm is serving the control API
+var ErrNotRunning = errors.New("tbm isn't running")
+
+// SocketPath returns the path of the control socket in the state directory
+func SocketPath(stateDir string) string {
+	return filepath.Join(stateDir, "tbm.sock")
+}
+
+// ServiceStatus is a service as reported by the control API
+type ServiceStatus struct {
+	Service     string `json:"service"`
+	Environment string `json:"environment"`
+	PID         int    `json:"pid,omitempty"`
+	Running     bool   `json:"running"`
+	// ExpiresAt is when a running service is stopped because it reaches its max lifetime
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// RemainingSeconds is the time left until ExpiresAt
+	RemainingSeconds int64 `json:"remaining_seconds,omitempty"`
+}
+
+// serveControl serves the control API on a unix socket that only the user can access, until the returned function
+// is called
+func (svc *ServicesService) serveControl(path string) (func(), error) {
+	if _, err := os.Stat(path); err == nil {
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, fmt.Errorf("another tbm is already running on %s", path)
+		}
+		// The socket is left over from a tbm that didn't stop properly
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, 0o600); err != nil {
+		listener.Close()
+		return nil, err
+	}
+
+	// closing is closed first when the control API is stopped, so clients following logs get a complete response
+	closing := make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/services", svc.handleServices)
+	mux.HandleFunc("/logs", func(w http.ResponseWriter, r *http.Request) {
+		svc.handleLogs(w, r, closing)
+	})
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		//nolint
+		server.Serve(listener)
+	}()
+	return func() {
+		close(closing)
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		defer cancel()
+		//nolint
+		server.Shutdown(ctx)
+		//nolint
+		os.Remove(path)
+	}, nil
+}
+
+// handleServices lists all services with their process id and the time left until they reach their max lifetime. It
+// doesn't take the locks of the procs, they are held while hooks run.
+func (svc *ServicesService) handleServices(w http.ResponseWriter, r *http.Request) {
+	var services []ServiceStatus
+	for _, proc := range svc.procs {
+		status := ServiceStatus{Service: proc.ClearName(), Environment: proc.environment}
+		if pid := proc.pid.Load(); pid != 0 {
+			status.PID = int(pid)
+			status.Running = true
+			if expiry := proc.expiry(); !expiry.IsZero() {
+				status.ExpiresAt = &expiry
+				status.RemainingSeconds = int64(time.Until(expiry).Round(time.Second) / time.Second)
+			}
+		}
+		services = append(services, status)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	//nolint
+	json.NewEncoder(w).Encode(services)
+}
+
+// handleLogs writes the buffered lines of one or all services, oldest first. With follow, new lines are written
+// until the client disconnects or the control API is stopped.
+func (svc *ServicesService) handleLogs(w http.ResponseWriter, r *http.Request, closing <-chan struct{}) {
+	service, environment := r.URL.Query().Get("service"), r.URL.Query().Get("env")
+	var procs []*Info
+	for _, proc := range svc.procs {
+		if proc.ring == nil || (service != "" && proc.ClearName() != service) || (environment != "" && proc.environment != environment) {
+			continue
+		}
+		procs = append(procs, proc)
+	}
+	if len(procs) == 0 && service == "" {
+		http.Error(w, "no services are running in tbm", http.StatusNotFound)
+		return
+	}
+	if len(procs) == 0 {
+		http.Error(w, fmt.Sprintf("service %s isn't running in tbm", service), http.StatusNotFound)
+		return
+	}
+	if service != "" && len(procs) > 1 {
+		var environments []string
+		for _, proc := range procs {
+			environments = append(environments, proc.environment)
+		}
+		http.Error(w, fmt.Sprintf("service %s runs in the environments %s, select one with --env", service, strings.Join(environments, ", ")), http.StatusConflict)
+		return
+	}
+
+	follow := r.URL.Query().Get("follow") == "true"
+	ch := make(chan log.Record, 1024)
+	var records []log.Record
+	for _, proc := range procs {
+		var procRecords []log.Record
+		if follow {
+			var cancel func()
+			procRecords, cancel = proc.ring.Subscribe(ch)
+			defer cancel()
+		} else {
+			procRecords = proc.ring.Records()
+		}
+		records = append(records, procRecords...)
+	}
+	sort.SliceStable(records, func(i, j int) bool { return records[i].Time.Before(records[j].Time) })
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	for _, record := range records {
+		if _, err := io.WriteString(w, log.FormatLine(record)); err != nil {
+			return
+		}
+	}
+	if !follow {
+		return
+	}
+	flusher, _ := w.(http.Flusher)
+	for {
+		if flusher != nil {
+			flusher.Flush()
+		}
+		select {
+		case record := <-ch:
+			if _, err := io.WriteString(w, log.FormatLine(record)); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		case <-closing:
+			// Pass on the records written while tbm was stopping
+			for {
+				select {
+				case record := <-ch:
+					//nolint
+					io.WriteString(w, log.FormatLine(record))
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// ControlClient talks to the control API of a running tbm
+type ControlClient struct {
+	client *http.Client
+}
+
+// NewControlClient returns a client for the control socket at path
+func NewControlClient(path string) *ControlClient {
+	return &ControlClient{client: &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}}}
+}
+
+// get requests a path of the control API, ErrNotRunning is returned if there's no tbm listening
+func (c *ControlClient) get(path string) (*http.Response, error) {
+	resp, err := c.client.Get("http://tbm" + path)
+	if err != nil {
+		return nil, ErrNotRunning
+	}
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, errors.New(strings.TrimSpace(string(message)))
+	}
+	return resp, nil
+}
+
+// Services returns all services of the running tbm
+func (c *ControlClient) Services() ([]ServiceStatus, error) {
+	resp, err := c.get("/services")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var services []ServiceStatus
+	if err := json.NewDecoder(resp.Body).Decode(&services); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+// Logs calls fn with every buffered line of a service, or of all services if service is empty. With follow, it
+// keeps calling fn with new lines until tbm stops.
+func (c *ControlClient) Logs(service string, environment string, follow bool, fn func(line string) error) error {
+	query := url.Values{"service": {service}, "env": {environment}, "follow": {strconv.FormatBool(follow)}}
+	resp, err := c.get("/logs?" + query.Encode())
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	r := bufio.NewReader(resp.Body)
+	for {
+		line, err := r.ReadString('\n')
+		if len(line) > 0 {
+			if err := fn(line); err != nil {
+				return err
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
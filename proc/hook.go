@@ -0,0 +1,116 @@
+package proc
+
+import (
+	"fmt"
+	"github.com/dewey/tbm/config"
+	"github.com/dewey/tbm/log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Names of the hooks, they are also used to prefix their output
+const (
+	hookPreStart  = "pre_start"
+	hookPostStart = "post_start"
+	hookPreStop   = "pre_stop"
+	hookPostStop  = "post_stop"
+	hookBefore    = "before"
+	hookAfter     = "after"
+)
+
+// interpolateHooks replaces the variable placeholders in the hooks of a service
+func interpolateHooks(service config.Service) (config.ServiceHooks, error) {
+	hooks := service.Hooks
+	for _, hook := range []**config.Hook{&hooks.PreStart, &hooks.PostStart, &hooks.PreStop, &hooks.PostStop} {
+		if *hook == nil {
+			continue
+		}
+		interpolated := **hook
+		command, err := service.Interpolate(interpolated.Command)
+		if err != nil {
+			return hooks, err
+		}
+		interpolated.Command = command
+		*hook = &interpolated
+	}
+	return hooks, nil
+}
+
+// runHook runs a hook of a proc and returns its error. Hooks that aren't configured are skipped.
+func (svc *ServicesService) runHook(proc *Info, name string, hook *config.Hook) error {
+	if hook == nil || hook.Command == "" {
+		return nil
+	}
+	env := append(proc.env(), "TBM_HOOK="+name)
+	return runCommand(proc.logger, hook.Command, "["+name+"] ", env, hook.TimeoutOrDefault())
+}
+
+// runHookAndLog runs a hook of a proc, errors are only logged as they can't change what happens to the proc anymore
+func (svc *ServicesService) runHookAndLog(proc *Info, name string, hook *config.Hook) {
+	if err := svc.runHook(proc, name, hook); err != nil {
+		proc.logger.Printf("%s hook of %s failed: %s\n", name, proc.ClearName(), err)
+	}
+}
+
+// runGlobalHook runs a hook that doesn't belong to a single proc, the names of all procs are passed in $TBM_SERVICES
+func (svc *ServicesService) runGlobalHook(name string, hook *config.Hook) error {
+	if hook == nil || hook.Command == "" {
+		return nil
+	}
+	var names []string
+	for _, proc := range svc.procs {
+		names = append(names, proc.name)
+	}
+	env := []string{"TBM_HOOK=" + name, "TBM_SERVICES=" + strings.Join(names, ",")}
+	return runCommand(svc.logger, hook.Command, "["+name+"] ", env, hook.TimeoutOrDefault())
+}
+
+// env returns the TBM_* environment variables describing a proc, they are passed to commands run by tbm
+func (p *Info) env() []string {
+	env := []string{
+		"TBM_SERVICE=" + p.ClearName(),
+		"TBM_ENVIRONMENT=" + p.environment,
+	}
+	env = append(env, p.interpolated.Load().variables...)
+	if p.setPort {
+		env = append(env, fmt.Sprintf("TBM_PORT=%d", p.port))
+	}
+	return env
+}
+
+// runCommand runs a command through the shell, like a hook or the command of an output rule. Its output is written
+// to the logger with the given prefix, and it's killed after the timeout.
+func runCommand(logger *log.Clogger, command string, prefix string, env []string, timeout time.Duration) error {
+	w := logger.Prefixed(prefix)
+	defer w.Close()
+
+	cs := append(cmdStart, command)
+	//nolint:gosec
+	cmd := exec.Command(cs[0], cs[1:]...)
+	cmd.Stdin = nil
+	cmd.Stdout = w
+	cmd.Stderr = w
+	cmd.Env = append(os.Environ(), env...)
+	cmd.SysProcAttr = procAttrs
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		//nolint
+		killProc(cmd.Process)
+		<-done
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+}
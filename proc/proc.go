@@ -4,7 +4,9 @@ import (
 	"errors"
 	"fmt"
 	"github.com/dewey/tbm/config"
+	"github.com/dewey/tbm/history"
 	"github.com/dewey/tbm/log"
+	"github.com/dewey/tbm/redact"
 	"golang.org/x/sys/unix"
 	"os"
 	"os/exec"
@@ -12,6 +14,7 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -19,11 +22,34 @@ import (
 type ServicesService struct {
 	// Configuration is the configuration read from the yaml file
 	Configuration config.Configuration
+	// History records every start and stop of a proc, it's disabled if nil
+	History *history.Log
+	// Sink receives the output of all procs, the default sink for the terminal is used if it's nil
+	Sink log.Sink
+	// LogDir is the directory every proc writes its own log file to, log files are disabled if it's empty
+	LogDir string
+	// SocketPath is the unix socket the control API is served on, it's disabled if empty
+	SocketPath string
+	// Resolver resolves variables from providers every time a proc is started
+	Resolver config.VariableResolver
 	// maxProcNameLength is the longest name of a proc. This is used to align the console output properly.
 	maxProcNameLength int
 	// procs is the in-memory representation of all currently running processes
 	procs []*Info
 	mu    sync.Mutex
+	// done is closed once tbm is stopping, waiting procs and schedules return when it's closed
+	done     chan struct{}
+	doneOnce sync.Once
+	// failCh receives an error if an output rule stops tbm
+	failCh chan error
+	// background counts the hooks and commands of output rules running in their own go routine, the log files are
+	// closed once they finished
+	background sync.WaitGroup
+	// logger is used for messages and global hooks that don't belong to a single proc
+	logger *log.Clogger
+	// secrets are the values of secret variables of all procs, they are redacted by the logger
+	secrets   map[string]struct{}
+	secretsMu sync.Mutex
 }
 
 // NewServicesService returns a new services service
@@ -33,31 +59,62 @@ func NewServicesService(cfg config.Configuration) *ServicesService {
 		maxProcNameLength: 0,
 		procs:             []*Info{},
 		mu:                sync.Mutex{},
+		done:              make(chan struct{}),
+		failCh:            make(chan error, 1),
 	}
 }
 
-var colors = []int{
-	32, // green
-	36, // cyan
-	35, // magenta
-	33, // yellow
-	34, // blue
-	31, // red
-}
-
 // Info defines the structure of a single process
 type Info struct {
 	name        string
+	service     string
 	environment string
-	cmdline     string
-	cmd         *exec.Cmd
+	// definition is the configuration of the service, variables from providers are resolved on every start
+	definition config.Service
+	// interpolated is replaced as a whole when variables from providers are resolved, so it can be read without
+	// holding the lock of the proc
+	interpolated atomic.Pointer[interpolation]
+	cmd          *exec.Cmd
+	// pid is the process id of cmd while it's running, the control API reads it without waiting for the lock
+	pid         atomic.Int64
 	port        uint
 	setPort     bool
-	colorIndex  int
+	color       log.Color
+	protected   bool
+	quietStdout bool
+	logger      *log.Clogger
+	logFile     *log.RotatingFileSink
+	// ring keeps the recent output of the proc for the control API and the exit summary
+	ring *log.RingSink
+
+	// oneshot procs run to completion, scheduled procs are run every time the schedule is due
+	oneshot   bool
+	schedule  *config.Schedule
+	dependsOn []string
+
+	// maxLifetime and restartEvery only apply to long-running procs, expiresAt is set on the first start in unix
+	// nanoseconds and read by the control API without the lock
+	maxLifetime      time.Duration
+	restartEvery     time.Duration
+	expiresAt        atomic.Int64
+	restartRequested bool
+
+	// triggers are the compiled output rules, restarting is set while a restart triggered by them is happening
+	triggers      []trigger
+	readyOnOutput bool
+	restarting    int32
+
+	// ready is closed once dependent procs can be started, failed if they never can
+	ready      chan struct{}
+	readyOnce  sync.Once
+	failed     chan struct{}
+	failedOnce sync.Once
 
 	// True if we called stopProc to kill the process, in which case an
 	// *os.ExitError is not the fault of the subprocess
 	stoppedBySupervisor bool
+	// postStopping is set while the post_stop hook runs, stopping the proc waits for it
+	postStopping bool
 
 	mu      sync.Mutex
 	cond    *sync.Cond
@@ -86,6 +143,35 @@ func (p *Info) ClearName() string {
 	return ClearName(p.name, p.environment)
 }
 
+// markReady signals dependent procs that they can be started
+func (p *Info) markReady() {
+	p.readyOnce.Do(func() {
+		close(p.ready)
+		ready := log.Event{Type: log.EventReady}
+		if p.readyOnOutput {
+			ready.Message = fmt.Sprintf("%s is ready", p.ClearName())
+		}
+		p.logger.Event(ready)
+	})
+}
+
+// hasFailed returns true if the proc couldn't be started or exited with an error tbm didn't cause
+func (p *Info) hasFailed() bool {
+	select {
+	case <-p.failed:
+		return true
+	default:
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.waitErr != nil && !p.stoppedBySupervisor
+}
+
+// markFailed signals dependent procs that they will never be started
+func (p *Info) markFailed() {
+	p.failedOnce.Do(func() { close(p.failed) })
+}
+
 // ClearName returns the clear service name of a proc
 func ClearName(name string, environment string) string {
 	return strings.Replace(name, "-"+environment, "", -1)
@@ -94,31 +180,95 @@ func ClearName(name string, environment string) string {
 // spawnProc starts the specified proc, and returns any error from running it.
 func (svc *ServicesService) spawnProc(name string, errCh chan<- error) {
 	cproc := svc.FindProc(name)
-	logger := log.New(name, cproc.environment, cproc.colorIndex, svc.maxProcNameLength)
+	logger := cproc.logger
+
+	// Don't start anything new if tbm is already stopping
+	select {
+	case <-svc.done:
+		return
+	default:
+	}
 
-	cs := append(cmdStart, cproc.cmdline)
+	if err := svc.resolve(cproc); err != nil {
+		select {
+		case errCh <- err:
+		default:
+		}
+		cproc.markFailed()
+		logger.Event(log.Event{Type: log.EventFailed, Message: fmt.Sprintf("Not starting %s: %s", cproc.ClearName(), err)})
+		return
+	}
+	interpolated := cproc.interpolated.Load()
+	cs := append(cmdStart, interpolated.cmdline)
 	//nolint:gosec
 	cmd := exec.Command(cs[0], cs[1:]...)
 	cmd.Stdin = nil
 	cmd.Stdout = logger
-	cmd.Stderr = logger
+	cmd.Stderr = logger.Stderr()
 	cmd.SysProcAttr = procAttrs
 
+	if cproc.maxLifetime > 0 && cproc.expiry().IsZero() {
+		cproc.expiresAt.Store(time.Now().Add(cproc.maxLifetime).UnixNano())
+		svc.scheduleExpiry(cproc)
+	}
+	var lifetime string
+	if expiry := cproc.expiry(); !expiry.IsZero() {
+		lifetime = fmt.Sprintf(" (stops in %s at %s)", time.Until(expiry).Round(time.Second), expiry.Format("15:04:05"))
+	}
+
+	starting := log.Event{Type: log.EventStarting, Port: cproc.port}
 	if cproc.setPort {
-		fmt.Fprintf(logger, "Starting %s on port %d\n", cproc.ClearName(), cproc.port)
+		starting.Message = fmt.Sprintf("Starting %s on port %d%s", cproc.ClearName(), cproc.port, lifetime)
+	} else if lifetime != "" {
+		starting.Message = fmt.Sprintf("Starting %s%s", cproc.ClearName(), lifetime)
+	} else if cproc.oneshot || cproc.schedule != nil {
+		starting.Message = fmt.Sprintf("Running %s", cproc.ClearName())
+	}
+	logger.Event(starting)
+	if err := svc.runHook(cproc, hookPreStart, interpolated.hooks.PreStart); err != nil {
+		select {
+		case errCh <- err:
+		default:
+		}
+		cproc.markFailed()
+		logger.Event(log.Event{Type: log.EventFailed, Message: fmt.Sprintf("Not starting %s, %s hook failed: %s", cproc.ClearName(), hookPreStart, err)})
+		return
 	}
 	if err := cmd.Start(); err != nil {
 		select {
 		case errCh <- err:
 		default:
 		}
-		fmt.Fprintf(logger, "Failed to start %s: %s\n", name, err)
+		cproc.markFailed()
+		logger.Event(log.Event{Type: log.EventFailed, Message: fmt.Sprintf("Failed to start %s: %s", name, err)})
 		return
 	}
 	cproc.cmd = cmd
 	cproc.stoppedBySupervisor = false
+	cproc.waitErr = nil
+	cproc.pid.Store(int64(cmd.Process.Pid))
+	logger.SetPID(cmd.Process.Pid)
+	svc.record(cproc, history.Entry{Event: history.EventStart, PID: cmd.Process.Pid})
+	if !cproc.oneshot && cproc.schedule == nil && !cproc.readyOnOutput {
+		cproc.markReady()
+	}
+	if interpolated.hooks.PostStart != nil {
+		svc.goBackground(func() { svc.runHookAndLog(cproc, hookPostStart, interpolated.hooks.PostStart) })
+	}
+	var restartTimer *time.Timer
+	if cproc.restartEvery > 0 {
+		restartTimer = time.AfterFunc(cproc.restartEvery, func() {
+			logger.Event(log.Event{Type: log.EventRestarting, Message: fmt.Sprintf("Restarting %s, it's restarted every %s", cproc.ClearName(), cproc.restartEvery)})
+			if err := svc.restartProc(name); err != nil {
+				logger.Printf("Failed to restart %s: %s\n", cproc.ClearName(), err)
+			}
+		})
+	}
 	cproc.mu.Unlock()
 	err := cmd.Wait()
+	if restartTimer != nil {
+		restartTimer.Stop()
+	}
 	cproc.mu.Lock()
 	cproc.cond.Broadcast()
 	if err != nil && !cproc.stoppedBySupervisor {
@@ -129,12 +279,100 @@ func (svc *ServicesService) spawnProc(name string, errCh chan<- error) {
 	}
 	cproc.waitErr = err
 	cproc.cmd = nil
-	fmt.Fprintf(logger, "Terminating %s\n", name)
+	stop := history.Entry{Event: history.EventStop, PID: cmd.Process.Pid, ExitStatus: cmd.ProcessState.ExitCode()}
+	if err != nil {
+		stop.Error = err.Error()
+	}
+	svc.record(cproc, stop)
+	exitCode := cmd.ProcessState.ExitCode()
+	switch {
+	case !cproc.oneshot && cproc.schedule == nil:
+		logger.Event(log.Event{Type: log.EventTerminated, Message: fmt.Sprintf("Terminating %s", name), ExitCode: &exitCode})
+	case err != nil:
+		cproc.markFailed()
+		logger.Event(log.Event{Type: log.EventFailed, Message: fmt.Sprintf("%s failed: %s", cproc.ClearName(), err), ExitCode: &exitCode})
+	default:
+		cproc.markReady()
+		logger.Event(log.Event{Type: log.EventTerminated, Message: fmt.Sprintf("Finished %s successfully", cproc.ClearName()), ExitCode: &exitCode})
+	}
+	cproc.pid.Store(0)
+	logger.SetPID(0)
+	// The hook may take a while, the proc isn't locked while it runs so its status can still be read and changed
+	cproc.postStopping = true
+	cproc.mu.Unlock()
+	svc.runHookAndLog(cproc, hookPostStop, interpolated.hooks.PostStop)
+	cproc.mu.Lock()
+	cproc.postStopping = false
+	cproc.cond.Broadcast()
+}
+
+// record adds an entry for the proc to the history, if it's enabled
+func (svc *ServicesService) record(proc *Info, e history.Entry) {
+	if svc.History == nil {
+		return
+	}
+	e.Service = proc.ClearName()
+	e.Environment = proc.environment
+	e.Port = proc.port
+	e.Command = proc.interpolated.Load().redactedCmdline
+	if err := svc.History.Append(e); err != nil {
+		proc.logger.Printf("Failed to record %s in history: %s\n", proc.ClearName(), err)
+	}
+}
+
+// expiry returns when the proc is stopped because it reached its max lifetime, it's zero if it has none
+func (p *Info) expiry() time.Time {
+	if expiresAt := p.expiresAt.Load(); expiresAt != 0 {
+		return time.Unix(0, expiresAt)
+	}
+	return time.Time{}
+}
+
+// lifetimeWarning is how long before the end of its max lifetime a warning is logged for a proc
+const lifetimeWarning = 5 * time.Minute
+
+// scheduleExpiry logs a warning shortly before the max lifetime of a proc is reached and stops it once it is
+func (svc *ServicesService) scheduleExpiry(proc *Info) {
+	warning := lifetimeWarning
+	if warning > proc.maxLifetime/2 {
+		warning = proc.maxLifetime / 2
+	}
+	expiry := proc.expiry()
+	time.AfterFunc(time.Until(expiry.Add(-warning)), func() {
+		proc.mu.Lock()
+		running := proc.cmd != nil
+		proc.mu.Unlock()
+		if running {
+			proc.logger.Printf("Warning: %s will be stopped in %s, it reaches its max lifetime of %s\n", proc.ClearName(), time.Until(expiry).Round(time.Second), proc.maxLifetime)
+		}
+	})
+	time.AfterFunc(time.Until(expiry), func() {
+		proc.mu.Lock()
+		running := proc.cmd != nil
+		proc.mu.Unlock()
+		if !running {
+			return
+		}
+		proc.logger.Event(log.Event{Type: log.EventStopping, Message: fmt.Sprintf("Stopping %s, it reached its max lifetime of %s", proc.ClearName(), proc.maxLifetime)})
+		if err := svc.stopProc(proc.name, nil); err != nil {
+			proc.logger.Printf("Failed to stop %s: %s\n", proc.ClearName(), err)
+		}
+	})
 }
 
 // stopProc is stopping the specified process. Issuing os.Kill if it does not terminate within 10 seconds. If signal is
 // nil, os.Interrupt is used.
 func (svc *ServicesService) stopProc(name string, signal os.Signal) error {
+	return svc.stopOrRestartProc(name, signal, false)
+}
+
+// restartProc stops the specified process, startProc is spawning it again once it terminated
+func (svc *ServicesService) restartProc(name string) error {
+	return svc.stopOrRestartProc(name, nil, true)
+}
+
+// stopOrRestartProc is stopping a process, and flags it to be spawned again if restart is set
+func (svc *ServicesService) stopOrRestartProc(name string, signal os.Signal, restart bool) error {
 	if signal == nil {
 		signal = os.Interrupt
 	}
@@ -144,13 +382,27 @@ func (svc *ServicesService) stopProc(name string, signal os.Signal) error {
 	}
 
 	proc.mu.Lock()
-	defer proc.mu.Unlock()
-
-	if proc.cmd == nil {
+	cmd := proc.cmd
+	if cmd == nil || proc.stoppedBySupervisor {
+		// It isn't running or another stop is already running the hooks, either way it's stopped once the post_stop
+		// hook finished
+		proc.waitStopped(cmd)
+		proc.mu.Unlock()
 		return nil
 	}
 	proc.stoppedBySupervisor = true
+	proc.restartRequested = restart
+	// The hook may take a while, the proc isn't locked while it runs so its status can still be read and changed
+	proc.mu.Unlock()
+	svc.runHookAndLog(proc, hookPreStop, proc.interpolated.Load().hooks.PreStop)
 
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	if proc.cmd != cmd {
+		// It terminated while the hook was running
+		proc.waitStopped(cmd)
+		return nil
+	}
 	err := terminateProc(proc, signal)
 	if err != nil {
 		return err
@@ -159,38 +411,146 @@ func (svc *ServicesService) stopProc(name string, signal os.Signal) error {
 	timeout := time.AfterFunc(10*time.Second, func() {
 		proc.mu.Lock()
 		defer proc.mu.Unlock()
-		if proc.cmd != nil {
-			err = killProc(proc.cmd.Process)
+		if proc.cmd == cmd {
+			err = killProc(cmd.Process)
 		}
 	})
-	proc.cond.Wait()
+	proc.waitStopped(cmd)
 	timeout.Stop()
 	return err
 }
 
+// waitStopped waits until cmd isn't running anymore and the post_stop hook finished, the lock of the proc must be held
+func (p *Info) waitStopped(cmd *exec.Cmd) {
+	for (cmd != nil && p.cmd == cmd) || p.postStopping {
+		p.cond.Wait()
+	}
+}
+
+// interpolation is the command line, hooks and variables of a proc with the values of the variables of its service
+type interpolation struct {
+	cmdline string
+	// redactedCmdline is the command line without sensitive variable values, used for records
+	redactedCmdline string
+	// hooks are already interpolated, variables are passed to them as TBM_VAR_* environment variables
+	hooks     config.ServiceHooks
+	variables []string
+	// secrets are the values of secret variables, they are redacted in the output
+	secrets []string
+}
+
+// interpolate sets the command, hooks and variables of the proc from the variables of the service
+func (p *Info) interpolate(service config.Service) error {
+	cmdline, err := service.InterpolatedCommand()
+	if err != nil {
+		return err
+	}
+	redactedCmdline, err := service.RedactedCommand()
+	if err != nil {
+		return err
+	}
+	hooks, err := interpolateHooks(service)
+	if err != nil {
+		return err
+	}
+	var variables []string
+	for _, values := range service.Variables {
+		for name, value := range values {
+			variables = append(variables, fmt.Sprintf("TBM_VAR_%s=%s", strings.ToUpper(name), value))
+		}
+	}
+	p.interpolated.Store(&interpolation{
+		cmdline:         cmdline,
+		redactedCmdline: redactedCmdline,
+		hooks:           hooks,
+		variables:       variables,
+		secrets:         service.SecretValues(),
+	})
+	return nil
+}
+
+// resolve fills in the variables from providers of a proc before it's started, their values are redacted from then on
+func (svc *ServicesService) resolve(proc *Info) error {
+	if !proc.definition.HasProviders() {
+		return nil
+	}
+	if svc.Resolver == nil {
+		return errors.New("variables from providers can't be resolved")
+	}
+	service, err := proc.definition.Resolve(svc.Resolver)
+	if err != nil {
+		return err
+	}
+	if err := proc.interpolate(service); err != nil {
+		return err
+	}
+	secrets := proc.interpolated.Load().secrets
+	proc.logger.SetRedactor(redact.New(secrets...))
+	svc.addSecrets(secrets)
+	return nil
+}
+
+// addSecrets adds values of secret variables that the logger of tbm itself redacts
+func (svc *ServicesService) addSecrets(values []string) {
+	svc.secretsMu.Lock()
+	defer svc.secretsMu.Unlock()
+	if svc.secrets == nil {
+		svc.secrets = make(map[string]struct{})
+	}
+	for _, value := range values {
+		svc.secrets[value] = struct{}{}
+	}
+	var secrets []string
+	for secret := range svc.secrets {
+		secrets = append(secrets, secret)
+	}
+	if svc.logger != nil {
+		svc.logger.SetRedactor(redact.New(secrets...))
+	}
+}
+
 // ReadProcfile reads a configuration object and stores it in the global, in-memory object used to keep track of it.
 func (svc *ServicesService) ReadProcfile(cfg config.Configuration) error {
 	svc.mu.Lock()
 	defer svc.mu.Unlock()
 
 	svc.procs = []*Info{}
-	index := 0
-	for key, service := range cfg.Services {
+	for _, key := range cfg.ServiceNames() {
+		service := cfg.Services[key]
 		// Skip all the services that don't pass the validation (Not enabled, erroneous configuration etc.)
 		if !service.Valid() {
 			continue
 		}
 		// Create proc based on configuration
-		cmd, err := service.InterpolatedCommand()
+		schedule, err := service.ParsedSchedule()
 		if err != nil {
 			return err
 		}
 
+		color := log.ServiceColor(key)
+		if service.Color != "" {
+			if color, err = log.ParseColor(service.Color); err != nil {
+				return err
+			}
+		}
+
 		proc := &Info{
 			name:        fmt.Sprintf("%s-%s", key, service.Environment),
+			service:     key,
 			environment: service.Environment,
-			cmdline:     cmd,
-			colorIndex:  index,
+			definition:  service,
+			color:       color,
+			protected:   cfg.Protected(service.Environment),
+			quietStdout: service.QuietStdout,
+			oneshot:     service.IsOneshot(),
+			schedule:    schedule,
+			dependsOn:   service.DependsOn,
+			ready:       make(chan struct{}),
+			failed:      make(chan struct{}),
+		}
+		// Variables from providers are still empty, this makes sure the commands are valid templates
+		if err := proc.interpolate(service); err != nil {
+			return err
 		}
 		exists, val := service.VariableValue("port")
 		if exists {
@@ -201,9 +561,16 @@ func (svc *ServicesService) ReadProcfile(cfg config.Configuration) error {
 			proc.port = uint(i)
 			proc.setPort = true
 		}
+		if proc.triggers, err = compileTriggers(service.OnOutput); err != nil {
+			return err
+		}
+		proc.readyOnOutput = readyOnOutput(proc.triggers)
+		if !proc.oneshot && proc.schedule == nil {
+			proc.maxLifetime = cfg.MaxLifetime(service)
+			proc.restartEvery = service.RestartEvery
+		}
 		proc.cond = sync.NewCond(&proc.mu)
 		svc.procs = append(svc.procs, proc)
-		index = (index + 1) % len(colors)
 	}
 
 	if len(svc.procs) > svc.maxProcNameLength {
@@ -212,10 +579,113 @@ func (svc *ServicesService) ReadProcfile(cfg config.Configuration) error {
 	if len(svc.procs) == 0 {
 		return errors.New("no valid service entry in configuration file")
 	}
+	return svc.checkDependencyCycles()
+}
+
+// findService returns the proc of a service by the name used in the configuration file
+func (svc *ServicesService) findService(service string) *Info {
+	for _, proc := range svc.procs {
+		if proc.service == service {
+			return proc
+		}
+	}
 	return nil
 }
 
-// startProc a specified proc by name. If proc is started already, return nil.
+// checkDependencyCycles makes sure no proc is waiting for itself, directly or through other procs
+func (svc *ServicesService) checkDependencyCycles() error {
+	const (
+		visiting = iota + 1
+		visited
+	)
+	state := make(map[string]int)
+	var visit func(proc *Info, path []string) error
+	visit = func(proc *Info, path []string) error {
+		path = append(path, proc.service)
+		switch state[proc.service] {
+		case visiting:
+			return fmt.Errorf("dependency cycle between services: %s", strings.Join(path, " -> "))
+		case visited:
+			return nil
+		}
+		state[proc.service] = visiting
+		for _, dependency := range proc.dependsOn {
+			if dep := svc.findService(dependency); dep != nil {
+				if err := visit(dep, path); err != nil {
+					return err
+				}
+			}
+		}
+		state[proc.service] = visited
+		return nil
+	}
+	for _, proc := range svc.procs {
+		if err := visit(proc, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// awaitDependencies blocks until all dependencies of a proc are ready. It returns false if the proc shouldn't be
+// started because a dependency failed, isn't enabled or tbm is stopping.
+func (svc *ServicesService) awaitDependencies(proc *Info) bool {
+	for _, dependency := range proc.dependsOn {
+		dep := svc.findService(dependency)
+		if dep == nil {
+			proc.logger.Event(log.Event{Type: log.EventFailed, Message: fmt.Sprintf("Not starting %s, dependency %s is not enabled", proc.ClearName(), dependency)})
+			return false
+		}
+		select {
+		case <-dep.ready:
+			continue
+		default:
+		}
+		proc.logger.Printf("Waiting for %s before starting %s\n", dependency, proc.ClearName())
+		select {
+		case <-dep.ready:
+		case <-dep.failed:
+			proc.logger.Event(log.Event{Type: log.EventFailed, Message: fmt.Sprintf("Not starting %s, dependency %s failed", proc.ClearName(), dependency)})
+			return false
+		case <-svc.done:
+			return false
+		}
+	}
+	return true
+}
+
+// runSchedule runs a scheduled proc every time it's due, until tbm is stopped. Interval schedules run once right away,
+// cron schedules wait for their first match. Failed runs are logged but don't stop tbm.
+func (svc *ServicesService) runSchedule(proc *Info) {
+	next := time.Now()
+	if !proc.schedule.IsInterval() {
+		next = proc.schedule.Next(next)
+	}
+	for {
+		if next.IsZero() {
+			proc.logger.Printf("Schedule of %s never matches, not running it\n", proc.ClearName())
+			proc.markFailed()
+			return
+		}
+		if wait := time.Until(next); wait > 0 {
+			proc.logger.Printf("Next run of %s at %s\n", proc.ClearName(), next.Format("2006-01-02 15:04:05"))
+			timer := time.NewTimer(wait)
+			select {
+			case <-timer.C:
+			case <-svc.done:
+				timer.Stop()
+				return
+			}
+		}
+		proc.mu.Lock()
+		svc.spawnProc(proc.name, nil)
+		proc.mu.Unlock()
+		next = proc.schedule.Next(time.Now())
+	}
+}
+
+// startProc a specified proc by name. If proc is started already, return nil. Procs with dependencies are started once
+// all of them are ready.
 func (svc *ServicesService) startProc(name string, wg *sync.WaitGroup, errCh chan<- error) error {
 	proc := svc.FindProc(name)
 	if proc == nil {
@@ -227,14 +697,30 @@ func (svc *ServicesService) startProc(name string, wg *sync.WaitGroup, errCh cha
 		proc.mu.Unlock()
 		return nil
 	}
+	proc.mu.Unlock()
 
 	if wg != nil {
 		wg.Add(1)
 	}
 	go func() {
-		svc.spawnProc(name, errCh)
 		if wg != nil {
-			wg.Done()
+			defer wg.Done()
+		}
+		if !svc.awaitDependencies(proc) {
+			proc.markFailed()
+			return
+		}
+		if proc.schedule != nil {
+			svc.runSchedule(proc)
+			return
+		}
+		proc.mu.Lock()
+		for {
+			proc.restartRequested = false
+			svc.spawnProc(name, errCh)
+			if !proc.restartRequested {
+				break
+			}
 		}
 		proc.mu.Unlock()
 	}()
@@ -243,23 +729,155 @@ func (svc *ServicesService) startProc(name string, wg *sync.WaitGroup, errCh cha
 
 // stopProcs attempts to stop every running process and returns any non-nil
 // error, if one exists. stopProcs will wait until all procs have had an
-// opportunity to stop.
+// opportunity to stop. Procs are stopped at the same time, so their pre_stop
+// hooks don't add up.
 func (svc *ServicesService) stopProcs(sig os.Signal) error {
-	var err error
+	svc.doneOnce.Do(func() { close(svc.done) })
+	var (
+		wg    sync.WaitGroup
+		errMu sync.Mutex
+		err   error
+	)
 	for _, proc := range svc.procs {
-		stopErr := svc.stopProc(proc.name, sig)
-		if stopErr != nil {
-			err = stopErr
-		}
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			if stopErr := svc.stopProc(name, sig); stopErr != nil {
+				errMu.Lock()
+				err = stopErr
+				errMu.Unlock()
+			}
+		}(proc.name)
 	}
+	wg.Wait()
 	return err
 }
 
-// StartProcs starts all procs in separate go routines
+// StartProcs starts all procs in separate go routines. The global before hook is run first, the after hook once all
+// procs stopped.
 func (svc *ServicesService) StartProcs(sc <-chan os.Signal, exitOnError bool, exitOnStop bool) error {
+	svc.logger = log.New(log.Options{Name: "tbm", MaxProcNameLength: svc.maxProcNameLength, Sink: svc.Sink})
+	for _, proc := range svc.procs {
+		svc.addSecrets(proc.interpolated.Load().secrets)
+	}
+	if err := svc.runGlobalHook(hookBefore, svc.Configuration.Hooks.Before); err != nil {
+		return fmt.Errorf("%s hook failed, not starting any services: %w", hookBefore, err)
+	}
+	if svc.SocketPath != "" {
+		closeControl, err := svc.serveControl(svc.SocketPath)
+		if err != nil {
+			svc.logger.Printf("Control API disabled: %s\n", err)
+		} else {
+			defer closeControl()
+		}
+	}
+	err := svc.runProcs(sc, exitOnError, exitOnStop)
+	svc.printFailures()
+	if hookErr := svc.runGlobalHook(hookAfter, svc.Configuration.Hooks.After); hookErr != nil {
+		svc.logger.Printf("%s hook failed: %s\n", hookAfter, hookErr)
+	}
+	return err
+}
+
+// summaryLines is the number of recent lines shown for every failed proc when tbm exits
+const summaryLines = 10
+
+// printFailures shows the last lines of output of every proc that failed
+func (svc *ServicesService) printFailures() {
+	for _, proc := range svc.procs {
+		if proc.ring == nil || !proc.hasFailed() {
+			continue
+		}
+		var lines []log.Record
+		for _, r := range proc.ring.Records() {
+			if r.Kind == log.KindLine {
+				lines = append(lines, r)
+			}
+		}
+		if len(lines) == 0 {
+			continue
+		}
+		if len(lines) > summaryLines {
+			lines = lines[len(lines)-summaryLines:]
+		}
+		svc.logger.Printf("Last lines of %s (%s) before it failed:\n", proc.ClearName(), proc.environment)
+		for _, r := range lines {
+			svc.logger.Printf("  %s", log.FormatLine(r))
+		}
+	}
+}
+
+// procSink returns the sink for the output of a proc. Quiet procs don't write their standard output to the shared
+// sink, but their buffer and log file still get everything.
+func (svc *ServicesService) procSink(proc *Info) (log.Sink, error) {
+	sink := svc.Sink
+	if sink == nil {
+		sink = log.NewSink(log.FormatText, os.Stdout)
+	}
+	if proc.quietStdout {
+		sink = log.QuietStdout(sink)
+	}
+	proc.ring = log.NewRingSink(svc.Configuration.Logs.BufferOrDefault())
+	sink = log.Multi(sink, proc.ring)
+	if svc.LogDir == "" || svc.Configuration.Logs.Disable {
+		return sink, nil
+	}
+	file, err := log.NewRotatingFileSink(
+		log.FilePath(svc.LogDir, proc.ClearName(), proc.environment),
+		int64(svc.Configuration.Logs.MaxSizeOrDefault()),
+		svc.Configuration.Logs.MaxFilesOrDefault(),
+	)
+	if err != nil {
+		return nil, err
+	}
+	proc.logFile = file
+	return log.Multi(sink, file), nil
+}
+
+// goBackground runs f in its own go routine, the log files stay open until it returned
+func (svc *ServicesService) goBackground(f func()) {
+	svc.background.Add(1)
+	go func() {
+		defer svc.background.Done()
+		f()
+	}()
+}
+
+// closeLogFiles closes the log files of all procs
+func (svc *ServicesService) closeLogFiles() {
+	for _, proc := range svc.procs {
+		if proc.logFile != nil {
+			//nolint
+			proc.logFile.Close()
+		}
+	}
+}
+
+// runProcs starts all procs and keeps supervising them until they stopped or tbm is stopped
+func (svc *ServicesService) runProcs(sc <-chan os.Signal, exitOnError bool, exitOnStop bool) error {
 	var wg sync.WaitGroup
 	errCh := make(chan error, 1)
 
+	// Deferred functions run in reverse order, the log files are closed after all procs and their hooks finished
+	defer svc.closeLogFiles()
+	for _, proc := range svc.procs {
+		sink, err := svc.procSink(proc)
+		if err != nil {
+			return err
+		}
+		proc.logger = log.New(log.Options{
+			Name:              proc.name,
+			Environment:       proc.environment,
+			Color:             proc.color,
+			MaxProcNameLength: svc.maxProcNameLength,
+			Protected:         proc.protected,
+			LineHook:          svc.lineHook(proc),
+			Redactor:          redact.New(proc.interpolated.Load().secrets...),
+			Sink:              sink,
+		})
+	}
+	defer svc.background.Wait()
+	defer wg.Wait()
 	for _, proc := range svc.procs {
 		if err := svc.startProc(proc.name, &wg, errCh); err != nil {
 			continue
@@ -282,6 +900,11 @@ func (svc *ServicesService) StartProcs(sc <-chan os.Signal, exitOnError bool, ex
 				}
 				return err
 			}
+		case err := <-svc.failCh:
+			if errStopping := svc.stopProcs(os.Interrupt); errStopping != nil {
+				return errStopping
+			}
+			return err
 		case <-allProcsDone:
 			return svc.stopProcs(os.Interrupt)
 		case sig := <-sc:
@@ -0,0 +1,86 @@
+package proc
+
+import (
+	"github.com/dewey/tbm/log"
+	"io"
+	"strings"
+	"testing"
+)
+
+// testProcs returns procs of services that depend on the services in their map value
+func testProcs(dependencies map[string][]string) []*Info {
+	var procs []*Info
+	for service, dependsOn := range dependencies {
+		procs = append(procs, &Info{
+			name:        service + "-dev",
+			service:     service,
+			environment: "dev",
+			dependsOn:   dependsOn,
+			logger:      log.New(log.Options{Name: service, Sink: log.NewPlainSink(io.Discard)}),
+			ready:       make(chan struct{}),
+			failed:      make(chan struct{}),
+		})
+	}
+	return procs
+}
+
+func TestServicesService_checkDependencyCycles(t *testing.T) {
+	tests := []struct {
+		name         string
+		dependencies map[string][]string
+		wantErr      string
+	}{
+		{name: "no dependencies", dependencies: map[string][]string{"db": nil, "cache": nil}},
+		{name: "chain", dependencies: map[string][]string{"vpn": nil, "db": {"vpn"}, "api": {"db", "vpn"}}},
+		{name: "disabled dependency", dependencies: map[string][]string{"api": {"db"}}},
+		{name: "itself", dependencies: map[string][]string{"db": {"db"}}, wantErr: "db -> db"},
+		{name: "two services", dependencies: map[string][]string{"db": {"vpn"}, "vpn": {"db"}}, wantErr: "dependency cycle"},
+		{name: "three services", dependencies: map[string][]string{"a": {"b"}, "b": {"c"}, "c": {"a"}, "d": {"a"}}, wantErr: "dependency cycle"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &ServicesService{procs: testProcs(tt.dependencies)}
+			err := svc.checkDependencyCycles()
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("checkDependencyCycles() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("checkDependencyCycles() error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestServicesService_awaitDependencies(t *testing.T) {
+	tests := []struct {
+		name  string
+		setup func(db *Info, svc *ServicesService)
+		want  bool
+	}{
+		{name: "ready", setup: func(db *Info, svc *ServicesService) { close(db.ready) }, want: true},
+		{name: "failed", setup: func(db *Info, svc *ServicesService) { close(db.failed) }},
+		{name: "stopping", setup: func(db *Info, svc *ServicesService) { close(svc.done) }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			procs := testProcs(map[string][]string{"db": nil, "api": {"db"}})
+			svc := &ServicesService{procs: procs, done: make(chan struct{})}
+			api, db := procs[0], procs[1]
+			if api.service != "api" {
+				api, db = db, api
+			}
+			tt.setup(db, svc)
+			if got := svc.awaitDependencies(api); got != tt.want {
+				t.Errorf("awaitDependencies() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	svc := &ServicesService{procs: testProcs(map[string][]string{"api": {"db"}}), done: make(chan struct{})}
+	if svc.awaitDependencies(svc.procs[0]) {
+		t.Errorf("awaitDependencies() = true for a dependency that isn't enabled")
+	}
+}
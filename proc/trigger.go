@@ -0,0 +1,115 @@
+package proc
+
+import (
+	"fmt"
+	"github.com/dewey/tbm/config"
+	"github.com/dewey/tbm/log"
+	"regexp"
+	"sync/atomic"
+	"time"
+)
+
+// triggerTimeout is how long a command run by an output rule may take
+const triggerTimeout = time.Minute
+
+// trigger is a compiled output rule of a proc
+type trigger struct {
+	match *regexp.Regexp
+	rule  config.OutputRule
+}
+
+// compileTriggers compiles the output rules of a service
+func compileTriggers(rules []config.OutputRule) ([]trigger, error) {
+	var triggers []trigger
+	for _, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			return nil, err
+		}
+		triggers = append(triggers, trigger{match: regexp.MustCompile(rule.Match), rule: rule})
+	}
+	return triggers, nil
+}
+
+// readyOnOutput returns true if the proc is only ready once a line of output matched
+func readyOnOutput(triggers []trigger) bool {
+	for _, t := range triggers {
+		if t.rule.Action == config.ActionReady {
+			return true
+		}
+	}
+	return false
+}
+
+// lineHook returns the function the logger calls with every line of output of a proc. Actions that take time are
+// run in their own go routine, the hook must not block the logger.
+func (svc *ServicesService) lineHook(proc *Info) func(line string) log.Level {
+	if len(proc.triggers) == 0 {
+		return nil
+	}
+	return func(line string) log.Level {
+		level := log.LevelInfo
+		for _, t := range proc.triggers {
+			if !t.match.MatchString(line) {
+				continue
+			}
+			switch t.rule.Action {
+			case config.ActionReady:
+				// The ready event is written to the logger, which is blocked while the hook is running
+				svc.goBackground(func() { svc.triggerReady(proc) })
+			case config.ActionRestart:
+				svc.goBackground(func() { svc.triggerRestart(proc, t.rule.Match) })
+			case config.ActionFail:
+				svc.fail(fmt.Errorf("stopped because the output of %s matched %q: %s", proc.ClearName(), t.rule.Match, line))
+			case config.ActionRun:
+				rule := t.rule
+				svc.goBackground(func() { svc.triggerRun(proc, rule, line) })
+			case config.ActionSeverity:
+				l := log.LevelWarning
+				if t.rule.Severity == config.SeverityError {
+					l = log.LevelError
+				}
+				if l > level {
+					level = l
+				}
+			}
+		}
+		return level
+	}
+}
+
+// triggerReady marks a proc as ready, unless it stopped since the line was written
+func (svc *ServicesService) triggerReady(proc *Info) {
+	proc.mu.Lock()
+	defer proc.mu.Unlock()
+	if proc.cmd != nil {
+		proc.markReady()
+	}
+}
+
+// triggerRestart restarts a proc, unless a restart triggered by its output is already happening
+func (svc *ServicesService) triggerRestart(proc *Info, match string) {
+	if !atomic.CompareAndSwapInt32(&proc.restarting, 0, 1) {
+		return
+	}
+	defer atomic.StoreInt32(&proc.restarting, 0)
+	proc.logger.Event(log.Event{Type: log.EventRestarting, Message: fmt.Sprintf("Restarting %s, its output matched %q", proc.ClearName(), match)})
+	if err := svc.restartProc(proc.name); err != nil {
+		proc.logger.Printf("Failed to restart %s: %s\n", proc.ClearName(), err)
+	}
+}
+
+// triggerRun runs the command of an output rule, the matching line is passed as $TBM_LINE
+func (svc *ServicesService) triggerRun(proc *Info, rule config.OutputRule, line string) {
+	env := append(proc.env(), "TBM_LINE="+line)
+	if err := runCommand(proc.logger, rule.Run, "[on_output] ", env, triggerTimeout); err != nil {
+		proc.logger.Printf("Command for output %q of %s failed: %s\n", rule.Match, proc.ClearName(), err)
+	}
+}
+
+// fail stops tbm with the given error
+func (svc *ServicesService) fail(err error) {
+	select {
+	case svc.failCh <- err:
+	default:
+	}
+}
@@ -0,0 +1,186 @@
+package proc
+
+import (
+	"github.com/dewey/tbm/config"
+	"github.com/dewey/tbm/log"
+	"os/exec"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// triggerProc returns a service with a proc of db with the output rules, its output is kept in the returned ring
+func triggerProc(t *testing.T, rules ...config.OutputRule) (*ServicesService, *Info, *log.RingSink) {
+	t.Helper()
+	ring := log.NewRingSink(100)
+	proc := &Info{
+		name:        "db-dev",
+		service:     "db",
+		environment: "dev",
+		ready:       make(chan struct{}),
+		failed:      make(chan struct{}),
+		logger:      log.New(log.Options{Name: "db-dev", Environment: "dev", Sink: ring}),
+	}
+	if err := proc.interpolate(config.Service{Command: "proxy"}); err != nil {
+		t.Fatal(err)
+	}
+	var err error
+	if proc.triggers, err = compileTriggers(rules); err != nil {
+		t.Fatal(err)
+	}
+	svc := &ServicesService{procs: []*Info{proc}, done: make(chan struct{}), failCh: make(chan error, 1)}
+	return svc, proc, ring
+}
+
+// hasRecord returns true if a record of the ring contains the text
+func hasRecord(ring *log.RingSink, text string) bool {
+	for _, r := range ring.Records() {
+		if strings.Contains(r.Message, text) {
+			return true
+		}
+	}
+	return false
+}
+
+func TestServicesService_lineHook(t *testing.T) {
+	tests := []struct {
+		name      string
+		rule      config.OutputRule
+		line      string
+		wantLevel log.Level
+		// running sets the command of the proc, without starting it
+		running bool
+		// stop stops the proc before the actions of the line run
+		stop  bool
+		check func(t *testing.T, svc *ServicesService, proc *Info, ring *log.RingSink)
+	}{
+		{
+			name:    "ready",
+			rule:    config.OutputRule{Match: "accepting connections", Action: config.ActionReady},
+			line:    "database is accepting connections",
+			running: true,
+			check: func(t *testing.T, svc *ServicesService, proc *Info, ring *log.RingSink) {
+				select {
+				case <-proc.ready:
+				case <-time.After(time.Second):
+					t.Errorf("proc isn't ready")
+				}
+			},
+		},
+		{
+			name: "not matching",
+			rule: config.OutputRule{Match: "accepting connections", Action: config.ActionReady},
+			line: "starting database",
+			check: func(t *testing.T, svc *ServicesService, proc *Info, ring *log.RingSink) {
+				time.Sleep(10 * time.Millisecond)
+				select {
+				case <-proc.ready:
+					t.Errorf("proc is ready, but the line didn't match")
+				default:
+				}
+			},
+		},
+		{
+			name:    "ready after the proc stopped",
+			rule:    config.OutputRule{Match: "accepting connections", Action: config.ActionReady},
+			line:    "database is accepting connections",
+			running: true,
+			stop:    true,
+			check: func(t *testing.T, svc *ServicesService, proc *Info, ring *log.RingSink) {
+				time.Sleep(10 * time.Millisecond)
+				select {
+				case <-proc.ready:
+					t.Errorf("proc is ready, but it stopped")
+				default:
+				}
+			},
+		},
+		{
+			name: "restart",
+			rule: config.OutputRule{Match: "token expired", Action: config.ActionRestart},
+			line: "error: token expired",
+			check: func(t *testing.T, svc *ServicesService, proc *Info, ring *log.RingSink) {
+				if !hasRecord(ring, `Restarting db, its output matched "token expired"`) {
+					t.Errorf("no restart was logged: %v", ring.Records())
+				}
+				if atomic.LoadInt32(&proc.restarting) != 0 {
+					t.Errorf("restarting is still set after the restart")
+				}
+			},
+		},
+		{
+			name: "fail",
+			rule: config.OutputRule{Match: "^FATAL", Action: config.ActionFail},
+			line: "FATAL: quota exceeded",
+			check: func(t *testing.T, svc *ServicesService, proc *Info, ring *log.RingSink) {
+				select {
+				case err := <-svc.failCh:
+					if !strings.Contains(err.Error(), "FATAL: quota exceeded") {
+						t.Errorf("fail error = %v, want it to contain the line", err)
+					}
+				default:
+					t.Errorf("tbm wasn't stopped")
+				}
+			},
+		},
+		{
+			name: "run",
+			rule: config.OutputRule{Match: "listening", Action: config.ActionRun, Run: `echo "$TBM_SERVICE saw: $TBM_LINE"`},
+			line: "listening on 5432",
+			check: func(t *testing.T, svc *ServicesService, proc *Info, ring *log.RingSink) {
+				if !hasRecord(ring, "db saw: listening on 5432") {
+					t.Errorf("output of the command is missing: %v", ring.Records())
+				}
+			},
+		},
+		{
+			name:      "warning",
+			rule:      config.OutputRule{Match: "(?i)deprecated", Action: config.ActionSeverity, Severity: config.SeverityWarning},
+			line:      "flag -x is DEPRECATED",
+			wantLevel: log.LevelWarning,
+		},
+		{
+			name:      "error",
+			rule:      config.OutputRule{Match: "error", Action: config.ActionSeverity, Severity: config.SeverityError},
+			line:      "connection error",
+			wantLevel: log.LevelError,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc, proc, ring := triggerProc(t, tt.rule)
+			if tt.running {
+				proc.cmd = exec.Command("proxy")
+			}
+			if tt.stop {
+				// Like the proc exiting while the actions wait for its lock
+				proc.mu.Lock()
+			}
+			if got := svc.lineHook(proc)(tt.line); got != tt.wantLevel {
+				t.Errorf("lineHook() level = %v, want %v", got, tt.wantLevel)
+			}
+			if tt.stop {
+				proc.cmd = nil
+				proc.mu.Unlock()
+			}
+			svc.background.Wait()
+			if tt.check != nil {
+				tt.check(t, svc, proc, ring)
+			}
+		})
+	}
+}
+
+func TestServicesService_lineHook_highestSeverity(t *testing.T) {
+	svc, proc, _ := triggerProc(t,
+		config.OutputRule{Match: "timeout", Action: config.ActionSeverity, Severity: config.SeverityError},
+		config.OutputRule{Match: "retry", Action: config.ActionSeverity, Severity: config.SeverityWarning},
+	)
+	if got := svc.lineHook(proc)("timeout, will retry"); got != log.LevelError {
+		t.Errorf("lineHook() level = %v, want the highest level %v", got, log.LevelError)
+	}
+	if hook := svc.lineHook(&Info{}); hook != nil {
+		t.Errorf("lineHook() of a proc without output rules isn't nil")
+	}
+}
@@ -0,0 +1,46 @@
+// Package redact hides the values of secrets in text that tbm prints or records.
+package redact
+
+import (
+	"sort"
+	"strings"
+)
+
+// Placeholder is shown instead of a secret value
+const Placeholder = "[redacted]"
+
+// Redactor replaces secret values in text with the placeholder. A nil Redactor leaves text unchanged.
+type Redactor struct {
+	replacer *strings.Replacer
+}
+
+// New returns a redactor for the given secrets, empty values are ignored. It returns nil if there's nothing to redact.
+func New(secrets ...string) *Redactor {
+	var values []string
+	seen := make(map[string]struct{})
+	for _, secret := range secrets {
+		if _, ok := seen[secret]; ok || secret == "" {
+			continue
+		}
+		seen[secret] = struct{}{}
+		values = append(values, secret)
+	}
+	if len(values) == 0 {
+		return nil
+	}
+	// Longer secrets come first, so a secret containing another one is replaced as a whole
+	sort.Slice(values, func(i, j int) bool { return len(values[i]) > len(values[j]) })
+	var oldnew []string
+	for _, value := range values {
+		oldnew = append(oldnew, value, Placeholder)
+	}
+	return &Redactor{replacer: strings.NewReplacer(oldnew...)}
+}
+
+// String returns s with all secrets replaced
+func (r *Redactor) String(s string) string {
+	if r == nil {
+		return s
+	}
+	return r.replacer.Replace(s)
+}
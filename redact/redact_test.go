@@ -0,0 +1,24 @@
+package redact
+
+import "testing"
+
+func TestRedactor_String(t *testing.T) {
+	tests := []struct {
+		name    string
+		secrets []string
+		s       string
+		want    string
+	}{
+		{name: "no secrets", s: "psql -p 1001", want: "psql -p 1001"},
+		{name: "empty secret", secrets: []string{""}, s: "psql -p 1001", want: "psql -p 1001"},
+		{name: "every occurrence", secrets: []string{"hunter2"}, s: "hunter2 and hunter2", want: "[redacted] and [redacted]"},
+		{name: "overlapping secrets", secrets: []string{"abc", "abcdef"}, s: "token abcdef", want: "token [redacted]"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := New(tt.secrets...).String(tt.s); got != tt.want {
+				t.Errorf("String() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
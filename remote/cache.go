@@ -0,0 +1,107 @@
+package remote
+
+import (
+	"errors"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CacheEntry describes the cached copy of a remote file
+type CacheEntry struct {
+	URL          string `yaml:"url"`
+	ETag         string `yaml:"etag,omitempty"`
+	LastModified string `yaml:"last_modified,omitempty"`
+	// FetchedAt is when the cached copy was downloaded
+	FetchedAt time.Time `yaml:"fetched_at"`
+	// CheckedAt is when the remote file was last confirmed to be the same as the cached copy
+	CheckedAt time.Time `yaml:"checked_at"`
+}
+
+// Cached is a remote file that was fetched or taken from the cache
+type Cached struct {
+	Content []byte
+	Entry   CacheEntry
+	// Offline is the reason the remote file couldn't be fetched, the content is the cached copy then
+	Offline error
+}
+
+// Age returns how long ago the content was confirmed to be current
+func (c Cached) Age(now time.Time) time.Duration {
+	return now.Sub(c.Entry.CheckedAt)
+}
+
+// LoadCache returns the cached copy at path, the entry is nil if there is none
+func LoadCache(path string) (*CacheEntry, []byte, error) {
+	b, err := os.ReadFile(path + ".meta")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var entry CacheEntry
+	if err := yaml.Unmarshal(b, &entry); err != nil {
+		return nil, nil, err
+	}
+	content, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	return &entry, content, nil
+}
+
+// saveCache writes the cached copy and its entry
+func saveCache(path string, entry CacheEntry, content []byte) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	b, err := yaml.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, content, 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(path+".meta", b, 0o600)
+}
+
+// FetchCached fetches a remote file and keeps a copy of it at path. The server is asked with a conditional request
+// if the cached copy is still current. If the server can't be reached or fails, the cached copy is returned with the
+// reason. Files that don't exist anymore are never taken from the cache.
+func (f *Fetcher) FetchCached(rawURL string, path string) (*Cached, error) {
+	entry, content, err := LoadCache(path)
+	if err != nil {
+		return nil, err
+	}
+	if entry != nil && entry.URL != rawURL {
+		entry, content = nil, nil
+	}
+
+	now := time.Now().UTC()
+	resp, err := f.get(rawURL, entry)
+	if err != nil {
+		var statusErr *StatusError
+		if entry == nil || (errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusNotFound || statusErr.StatusCode == http.StatusGone)) {
+			return nil, err
+		}
+		return &Cached{Content: content, Entry: *entry, Offline: err}, nil
+	}
+	if resp.notModified {
+		entry.CheckedAt = now
+		if resp.etag != "" {
+			entry.ETag = resp.etag
+		}
+		if resp.lastModified != "" {
+			entry.LastModified = resp.lastModified
+		}
+		return &Cached{Content: content, Entry: *entry}, saveCache(path, *entry, content)
+	}
+	fetched := CacheEntry{URL: rawURL, ETag: resp.etag, LastModified: resp.lastModified, FetchedAt: now, CheckedAt: now}
+	return &Cached{Content: resp.body, Entry: fetched}, saveCache(path, fetched, resp.body)
+}
@@ -0,0 +1,66 @@
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestFetcher_FetchCached(t *testing.T) {
+	var (
+		requests    atomic.Int32
+		conditional atomic.Int32
+		down        atomic.Bool
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if down.Load() {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			conditional.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Write([]byte(testConfig))
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "cache.yaml")
+	fetcher := NewFetcher(Options{NetrcPath: filepath.Join(t.TempDir(), "missing")})
+	for i, want := range []int32{0, 1} {
+		cached, err := fetcher.FetchCached(server.URL, path)
+		if err != nil {
+			t.Fatalf("FetchCached() %d error = %v", i, err)
+		}
+		if string(cached.Content) != testConfig || cached.Offline != nil {
+			t.Errorf("FetchCached() %d = %q (offline: %v), want the file", i, cached.Content, cached.Offline)
+		}
+		if got := conditional.Load(); got != want {
+			t.Errorf("FetchCached() %d made %d conditional requests, want %d", i, got, want)
+		}
+	}
+
+	down.Store(true)
+	cached, err := fetcher.FetchCached(server.URL, path)
+	if err != nil {
+		t.Fatalf("FetchCached() with the server down error = %v", err)
+	}
+	if string(cached.Content) != testConfig || cached.Offline == nil {
+		t.Errorf("FetchCached() with the server down = %q (offline: %v), want the cached copy", cached.Content, cached.Offline)
+	}
+	if cached.Entry.CheckedAt.IsZero() {
+		t.Errorf("cached copy has no time it was checked")
+	}
+
+	if _, err := fetcher.FetchCached(server.URL+"/other.yaml", path); err == nil {
+		t.Errorf("FetchCached() of another URL with the server down returned the cached copy of %s", server.URL)
+	}
+	if got := requests.Load(); got != 4 {
+		t.Errorf("server got %d requests, want 4", got)
+	}
+}
@@ -0,0 +1,224 @@
+package remote
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultTimeout is how long fetching a configuration file may take
+	DefaultTimeout = 30 * time.Second
+	// DefaultMaxSize is the largest configuration file that is fetched
+	DefaultMaxSize = 1024 * 1024
+)
+
+// Environment variables with credentials, used if they're not passed as options
+const (
+	EnvToken    = "TBM_REMOTE_TOKEN"
+	EnvUser     = "TBM_REMOTE_USER"
+	EnvPassword = "TBM_REMOTE_PASSWORD"
+)
+
+// Options configure how configuration files are fetched. If neither a token nor a user is set, the credentials are
+// taken from the environment and then from the .netrc file.
+type Options struct {
+	// Token is sent as bearer token
+	Token string
+	// User and Password are sent with basic auth
+	User     string
+	Password string
+	// Headers are added to every request
+	Headers http.Header
+	// Timeout defaults to DefaultTimeout
+	Timeout time.Duration
+	// MaxSize is the largest accepted response body in bytes, it defaults to DefaultMaxSize
+	MaxSize int64
+	// NetrcPath defaults to $NETRC or ~/.netrc
+	NetrcPath string
+	// Offline doesn't contact any server, only cached copies are returned
+	Offline bool
+}
+
+// Fetcher downloads configuration files over HTTP
+type Fetcher struct {
+	options Options
+	client  *http.Client
+}
+
+// NewFetcher returns a fetcher with the options
+func NewFetcher(options Options) *Fetcher {
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultTimeout
+	}
+	if options.MaxSize <= 0 {
+		options.MaxSize = DefaultMaxSize
+	}
+	if options.Token == "" && options.User == "" {
+		// Credentials passed as options always win over the environment
+		options.Token = os.Getenv(EnvToken)
+		options.User, options.Password = os.Getenv(EnvUser), os.Getenv(EnvPassword)
+	}
+	if options.NetrcPath == "" {
+		options.NetrcPath = defaultNetrcPath()
+	}
+	return &Fetcher{options: options, client: &http.Client{Timeout: options.Timeout}}
+}
+
+// StatusError is returned if the server doesn't respond with success
+type StatusError struct {
+	URL        string
+	Status     string
+	StatusCode int
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("fetching %s failed: %s", e.URL, e.Status)
+}
+
+// UnreachableError is returned if the server can't be reached, like without network or VPN, or doesn't answer in time
+type UnreachableError struct {
+	URL string
+	// Timeout is set if the server didn't answer in time
+	Timeout time.Duration
+	Err     error
+}
+
+func (e *UnreachableError) Error() string {
+	if e.Timeout > 0 {
+		return fmt.Sprintf("fetching %s timed out after %s", e.URL, e.Timeout)
+	}
+	return fmt.Sprintf("fetching %s failed: %s", e.URL, e.Err)
+}
+
+func (e *UnreachableError) Unwrap() error {
+	return e.Err
+}
+
+// errOffline is the reason remote files aren't fetched with the Offline option
+var errOffline = errors.New("tbm runs offline")
+
+// ParseHeader parses a header in the form "Name: value"
+func ParseHeader(header string) (string, string, error) {
+	name, value, ok := strings.Cut(header, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return "", "", fmt.Errorf("invalid header %q, use the form \"Name: value\"", header)
+	}
+	return name, strings.TrimSpace(value), nil
+}
+
+// Fetch downloads a configuration file. Only successful responses that don't look like web pages and aren't larger
+// than the maximum size are returned.
+func (f *Fetcher) Fetch(rawURL string) ([]byte, error) {
+	resp, err := f.get(rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.body, nil
+}
+
+// response is a fetched configuration file
+type response struct {
+	body []byte
+	// notModified is set if the cached copy is still current, the body is empty then
+	notModified  bool
+	etag         string
+	lastModified string
+}
+
+// get fetches a configuration file. If a cache entry is passed, the request is conditional.
+func (f *Fetcher) get(rawURL string, entry *CacheEntry) (*response, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL %q: %w", rawURL, err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("invalid URL %q, only http and https are supported", rawURL)
+	}
+	if f.options.Offline {
+		return nil, &UnreachableError{URL: u.Redacted(), Err: errOffline}
+	}
+	req, err := http.NewRequest(http.MethodGet, u.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	for name, values := range f.options.Headers {
+		for _, value := range values {
+			req.Header.Add(name, value)
+		}
+	}
+	req.Header.Set("Accept", "application/yaml, text/yaml, text/plain;q=0.9, */*;q=0.8")
+	f.authenticate(req)
+	if entry != nil {
+		if entry.ETag != "" {
+			req.Header.Set("If-None-Match", entry.ETag)
+		}
+		if entry.LastModified != "" {
+			req.Header.Set("If-Modified-Since", entry.LastModified)
+		}
+	}
+
+	resp, err := f.client.Do(req)
+	if err != nil {
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) && urlErr.Timeout() {
+			return nil, &UnreachableError{URL: u.Redacted(), Timeout: f.options.Timeout, Err: err}
+		}
+		if urlErr != nil {
+			// The error of the client repeats the URL
+			err = urlErr.Err
+		}
+		return nil, &UnreachableError{URL: u.Redacted(), Err: err}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotModified && entry != nil:
+		return &response{notModified: true, etag: resp.Header.Get("ETag"), lastModified: resp.Header.Get("Last-Modified")}, nil
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		statusErr := &StatusError{URL: u.Redacted(), Status: resp.Status, StatusCode: resp.StatusCode}
+		return nil, fmt.Errorf("%w, check the credentials passed with --token or --user, %s or .netrc", statusErr, EnvToken)
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return nil, &StatusError{URL: u.Redacted(), Status: resp.Status, StatusCode: resp.StatusCode}
+	}
+	if mediaType, _, err := mime.ParseMediaType(resp.Header.Get("Content-Type")); err == nil && (mediaType == "text/html" || mediaType == "application/xhtml+xml") {
+		return nil, fmt.Errorf("fetching %s returned a web page instead of a configuration file, check the URL and if it needs credentials", u.Redacted())
+	}
+	if resp.ContentLength > f.options.MaxSize {
+		return nil, fmt.Errorf("the configuration file at %s has %d bytes, more than the maximum of %d", u.Redacted(), resp.ContentLength, f.options.MaxSize)
+	}
+	b, err := io.ReadAll(io.LimitReader(resp.Body, f.options.MaxSize+1))
+	if err != nil {
+		return nil, fmt.Errorf("fetching %s failed: %w", u.Redacted(), err)
+	}
+	if int64(len(b)) > f.options.MaxSize {
+		return nil, fmt.Errorf("the configuration file at %s is larger than the maximum of %d bytes", u.Redacted(), f.options.MaxSize)
+	}
+	if len(strings.TrimSpace(string(b))) == 0 {
+		return nil, fmt.Errorf("the configuration file at %s is empty", u.Redacted())
+	}
+	return &response{body: b, etag: resp.Header.Get("ETag"), lastModified: resp.Header.Get("Last-Modified")}, nil
+}
+
+// authenticate adds the credentials of the options, the environment or the .netrc file to the request
+func (f *Fetcher) authenticate(req *http.Request) {
+	switch {
+	case f.options.Token != "":
+		req.Header.Set("Authorization", "Bearer "+f.options.Token)
+	case f.options.User != "":
+		req.SetBasicAuth(f.options.User, f.options.Password)
+	case req.URL.User != nil:
+		// The client uses the credentials in the URL
+	default:
+		if login, password, ok := netrcLogin(f.options.NetrcPath, req.URL.Hostname()); ok {
+			req.SetBasicAuth(login, password)
+		}
+	}
+}
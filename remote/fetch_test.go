@@ -0,0 +1,167 @@
+package remote
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testConfig = "services:\n    db:\n        command: proxy {{.port}}\n"
+
+func TestFetcher_Fetch(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/config.yaml":
+			w.Header().Set("Content-Type", "application/yaml")
+			w.Write([]byte(testConfig))
+		case "/bearer.yaml":
+			if r.Header.Get("Authorization") != "Bearer s3cret" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(testConfig))
+		case "/basic.yaml":
+			if user, password, ok := r.BasicAuth(); !ok || user != "alice" || password != "hunter2" {
+				w.WriteHeader(http.StatusForbidden)
+				return
+			}
+			w.Write([]byte(testConfig))
+		case "/header.yaml":
+			if r.Header.Get("X-Team") != "platform" {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(testConfig))
+		case "/login.html":
+			w.Header().Set("Content-Type", "text/html; charset=utf-8")
+			w.Write([]byte("<html><body>Please sign in</body></html>"))
+		case "/large.yaml":
+			w.Write([]byte(strings.Repeat("# padding\n", 1000)))
+		case "/empty.yaml":
+		case "/slow.yaml":
+			time.Sleep(200 * time.Millisecond)
+			w.Write([]byte(testConfig))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	netrc := filepath.Join(t.TempDir(), ".netrc")
+	host := strings.Split(strings.TrimPrefix(server.URL, "http://"), ":")[0]
+	if err := os.WriteFile(netrc, []byte("machine example.com login bob password nope\nmachine "+host+"\n  login alice\n  password hunter2\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv(EnvToken, "")
+	t.Setenv(EnvUser, "")
+	t.Setenv(EnvPassword, "")
+
+	tests := []struct {
+		name    string
+		path    string
+		options Options
+		wantErr string
+	}{
+		{name: "yaml", path: "/config.yaml"},
+		{name: "not found", path: "/missing.yaml", wantErr: "404 Not Found"},
+		{name: "bearer token", path: "/bearer.yaml", options: Options{Token: "s3cret"}},
+		{name: "missing token", path: "/bearer.yaml", wantErr: "check the credentials"},
+		{name: "basic auth", path: "/basic.yaml", options: Options{User: "alice", Password: "hunter2"}},
+		{name: "basic auth from netrc", path: "/basic.yaml", options: Options{NetrcPath: netrc}},
+		{name: "header", path: "/header.yaml", options: Options{Headers: http.Header{"X-Team": {"platform"}}}},
+		{name: "web page", path: "/login.html", wantErr: "returned a web page"},
+		{name: "too large", path: "/large.yaml", options: Options{MaxSize: 1024}, wantErr: "maximum"},
+		{name: "empty", path: "/empty.yaml", wantErr: "is empty"},
+		{name: "timeout", path: "/slow.yaml", options: Options{Timeout: 50 * time.Millisecond}, wantErr: "timed out"},
+		{name: "offline", path: "/config.yaml", options: Options{Offline: true}, wantErr: "tbm runs offline"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.options.NetrcPath == "" {
+				tt.options.NetrcPath = filepath.Join(t.TempDir(), "missing")
+			}
+			got, err := NewFetcher(tt.options).Fetch(server.URL + tt.path)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Fetch() error = %v, want it to contain %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Fetch() error = %v", err)
+			}
+			if string(got) != testConfig {
+				t.Errorf("Fetch() = %q, want %q", got, testConfig)
+			}
+		})
+	}
+}
+
+func TestNewFetcher_Credentials(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("# " + r.Header.Get("Authorization") + "\n"))
+	}))
+	defer server.Close()
+	t.Setenv(EnvToken, "from-env")
+	t.Setenv(EnvUser, "")
+	t.Setenv(EnvPassword, "")
+
+	tests := []struct {
+		name    string
+		options Options
+		want    string
+	}{
+		{name: "environment", want: "Bearer from-env"},
+		{name: "token option", options: Options{Token: "s3cret"}, want: "Bearer s3cret"},
+		{name: "user option", options: Options{User: "alice", Password: "hunter2"}, want: "Basic YWxpY2U6aHVudGVyMg=="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.options.NetrcPath = filepath.Join(t.TempDir(), "missing")
+			got, err := NewFetcher(tt.options).Fetch(server.URL)
+			if err != nil {
+				t.Fatalf("Fetch() error = %v", err)
+			}
+			if want := "# " + tt.want + "\n"; string(got) != want {
+				t.Errorf("Fetch() sent %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestNetrcLogin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), ".netrc")
+	content := `machine git.example.com login alice password one
+macdef init
+machine config.example.com login mallory password evil
+
+machine config.example.com
+    login bob
+    password two
+default login anonymous password guest
+`
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		host         string
+		wantLogin    string
+		wantPassword string
+	}{
+		{host: "git.example.com", wantLogin: "alice", wantPassword: "one"},
+		{host: "config.example.com", wantLogin: "bob", wantPassword: "two"},
+		{host: "other.example.com", wantLogin: "anonymous", wantPassword: "guest"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.host, func(t *testing.T) {
+			login, password, ok := netrcLogin(path, tt.host)
+			if !ok || login != tt.wantLogin || password != tt.wantPassword {
+				t.Errorf("netrcLogin() = %q, %q, %v, want %q, %q", login, password, ok, tt.wantLogin, tt.wantPassword)
+			}
+		})
+	}
+}
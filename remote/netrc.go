@@ -0,0 +1,95 @@
+package remote
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// defaultNetrcPath returns $NETRC or ~/.netrc
+func defaultNetrcPath() string {
+	if path := os.Getenv("NETRC"); path != "" {
+		return path
+	}
+	hd, err := os.UserHomeDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(hd, ".netrc")
+}
+
+// netrcLogin returns the login and password of a host in a .netrc file. The default entry is used if the host has
+// none.
+func netrcLogin(path string, host string) (string, string, bool) {
+	if path == "" {
+		return "", "", false
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", "", false
+	}
+	type entry struct {
+		login, password string
+	}
+	var (
+		current  *entry
+		found    *entry
+		fallback *entry
+	)
+	var fields []string
+	macro := false
+	for _, line := range strings.Split(string(b), "\n") {
+		// Macros run until the next empty line
+		if macro {
+			macro = strings.TrimSpace(line) != ""
+			continue
+		}
+		lineFields := strings.Fields(line)
+		for i, field := range lineFields {
+			if field == "macdef" {
+				lineFields, macro = lineFields[:i], true
+				break
+			}
+		}
+		fields = append(fields, lineFields...)
+	}
+	for i := 0; i < len(fields); i++ {
+		switch fields[i] {
+		case "machine":
+			current = nil
+			if i+1 < len(fields) {
+				i++
+				if fields[i] == host && found == nil {
+					found = &entry{}
+					current = found
+				}
+			}
+		case "default":
+			current = nil
+			if fallback == nil {
+				fallback = &entry{}
+				current = fallback
+			}
+		case "login", "password", "account":
+			if i+1 >= len(fields) {
+				continue
+			}
+			i++
+			if current == nil {
+				continue
+			}
+			if fields[i-1] == "login" {
+				current.login = fields[i]
+			} else if fields[i-1] == "password" {
+				current.password = fields[i]
+			}
+		}
+	}
+	if found == nil {
+		found = fallback
+	}
+	if found == nil || found.login == "" {
+		return "", "", false
+	}
+	return found.login, found.password, true
+}
@@ -0,0 +1,217 @@
+package remote
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The embedded signature block is made of YAML comments at the end of a configuration file, so the file stays valid
+const (
+	signatureBegin = "# -----BEGIN TBM SIGNATURE-----"
+	signatureEnd   = "# -----END TBM SIGNATURE-----"
+)
+
+// Prefixes of encoded keys
+const (
+	publicKeyPrefix  = "ed25519:"
+	privateKeyPrefix = "ed25519-private:"
+)
+
+// ErrUnsigned is returned if a configuration file has to be signed, but isn't
+var ErrUnsigned = errors.New("the configuration file isn't signed")
+
+// TrustedKey is a public key configuration files may be signed with
+type TrustedKey struct {
+	Key  ed25519.PublicKey
+	Name string
+}
+
+// GenerateKey returns a new encoded key pair
+func GenerateKey() (string, string, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return "", "", err
+	}
+	return FormatPublicKey(public), privateKeyPrefix + base64.StdEncoding.EncodeToString(private.Seed()), nil
+}
+
+// FormatPublicKey encodes a public key as it's trusted
+func FormatPublicKey(key ed25519.PublicKey) string {
+	return publicKeyPrefix + base64.StdEncoding.EncodeToString(key)
+}
+
+// ParsePublicKey decodes a public key of FormatPublicKey
+func ParsePublicKey(s string) (ed25519.PublicKey, error) {
+	b, err := decodeKey(s, publicKeyPrefix, ed25519.PublicKeySize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %w", err)
+	}
+	return ed25519.PublicKey(b), nil
+}
+
+// ParsePrivateKey decodes a private key of GenerateKey
+func ParsePrivateKey(s string) (ed25519.PrivateKey, error) {
+	b, err := decodeKey(s, privateKeyPrefix, ed25519.SeedSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid private key: %w", err)
+	}
+	return ed25519.NewKeyFromSeed(b), nil
+}
+
+func decodeKey(s string, prefix string, size int) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, prefix) {
+		return nil, fmt.Errorf("it has to start with %s", prefix)
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(s, prefix))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) != size {
+		return nil, fmt.Errorf("it has %d bytes instead of %d", len(b), size)
+	}
+	return b, nil
+}
+
+// Sign returns the detached signature of a configuration file
+func Sign(content []byte, key ed25519.PrivateKey) string {
+	return base64.StdEncoding.EncodeToString(ed25519.Sign(key, content))
+}
+
+// Embed returns the configuration file with its signature block at the end. An existing block is replaced.
+func Embed(content []byte, key ed25519.PrivateKey) ([]byte, error) {
+	body, _, err := SplitSignature(content)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) > 0 && !bytes.HasSuffix(body, []byte("\n")) {
+		body = append(body, '\n')
+	}
+	signed := append([]byte{}, body...)
+	signed = append(signed, signatureBegin+"\n# "+Sign(body, key)+"\n"+signatureEnd+"\n"...)
+	return signed, nil
+}
+
+// SplitSignature separates a configuration file from its embedded signature. The signature is empty if there's
+// no signature block.
+func SplitSignature(content []byte) ([]byte, string, error) {
+	start := bytes.Index(content, []byte(signatureBegin+"\n"))
+	if start == -1 {
+		return content, "", nil
+	}
+	if start > 0 && content[start-1] != '\n' {
+		return nil, "", errors.New("the signature block has to start on its own line")
+	}
+	var lines []string
+	scanner := bufio.NewScanner(bytes.NewReader(content[start+len(signatureBegin)+1:]))
+	for scanner.Scan() {
+		lines = append(lines, strings.TrimSpace(scanner.Text()))
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	if len(lines) != 2 || lines[1] != signatureEnd || !strings.HasPrefix(lines[0], "# ") {
+		return nil, "", errors.New("the signature block is malformed, it has to be the end of the file")
+	}
+	return content[:start], strings.TrimPrefix(lines[0], "# "), nil
+}
+
+// Verify checks the signature of a configuration file and returns the trusted key it was signed with
+func Verify(content []byte, signature string, keys []TrustedKey) (TrustedKey, error) {
+	if strings.TrimSpace(signature) == "" {
+		return TrustedKey{}, ErrUnsigned
+	}
+	b, err := base64.StdEncoding.DecodeString(strings.TrimSpace(signature))
+	if err != nil || len(b) != ed25519.SignatureSize {
+		return TrustedKey{}, errors.New("the signature of the configuration file is malformed")
+	}
+	for _, key := range keys {
+		if ed25519.Verify(key.Key, content, b) {
+			return key, nil
+		}
+	}
+	return TrustedKey{}, errors.New("the signature of the configuration file doesn't match any trusted key, it may have been tampered with")
+}
+
+// CheckSHA256 compares the checksum of a configuration file with a pinned hex encoded checksum
+func CheckSHA256(content []byte, pin string) error {
+	sum := sha256.Sum256(content)
+	if got := hex.EncodeToString(sum[:]); !strings.EqualFold(got, strings.TrimSpace(pin)) {
+		return fmt.Errorf("the checksum of the configuration file is %s, expected %s", got, pin)
+	}
+	return nil
+}
+
+// TrustedKeysPath returns the file with the public keys remote configuration files may be signed with
+func TrustedKeysPath() (string, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "tbm", "trusted_keys"), nil
+}
+
+// LoadTrustedKeys reads the trusted keys, one per line followed by an optional name. There are none if the file
+// doesn't exist.
+func LoadTrustedKeys(path string) ([]TrustedKey, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var keys []TrustedKey
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		encoded, name, _ := strings.Cut(line, " ")
+		key, err := ParsePublicKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", path, i+1, err)
+		}
+		keys = append(keys, TrustedKey{Key: key, Name: strings.TrimSpace(name)})
+	}
+	return keys, nil
+}
+
+// AddTrustedKey appends a key to the trusted keys, keys that are already trusted aren't added again
+func AddTrustedKey(path string, key TrustedKey) error {
+	keys, err := LoadTrustedKeys(path)
+	if err != nil {
+		return err
+	}
+	for _, trusted := range keys {
+		if trusted.Key.Equal(key.Key) {
+			return nil
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	line := FormatPublicKey(key.Key)
+	if key.Name != "" {
+		line += " " + key.Name
+	}
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
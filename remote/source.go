@@ -0,0 +1,67 @@
+// Package remote fetches shared configuration files and keeps track of where they came from.
+package remote
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// Source is where a configuration file was downloaded from, and which version of it was fetched last
+type Source struct {
+	URL       string    `yaml:"url"`
+	FetchedAt time.Time `yaml:"fetched_at"`
+	// SHA256 is the checksum of the last fetched version
+	SHA256 string `yaml:"sha256"`
+}
+
+// Dir returns the directory the source of a configuration file is kept in, inside the state directory
+func Dir(stateDir string, configPath string) (string, error) {
+	abs, err := filepath.Abs(configPath)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	return filepath.Join(stateDir, "remote", hex.EncodeToString(sum[:8])), nil
+}
+
+// Load returns the source and the last fetched version of a configuration file. The source is nil if the file
+// wasn't downloaded.
+func Load(dir string) (*Source, []byte, error) {
+	b, err := os.ReadFile(filepath.Join(dir, "source.yaml"))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, nil
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+	var source Source
+	if err := yaml.Unmarshal(b, &source); err != nil {
+		return nil, nil, err
+	}
+	base, err := os.ReadFile(filepath.Join(dir, "base.yaml"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, nil, err
+	}
+	return &source, base, nil
+}
+
+// Save stores the source and the version that was fetched
+func Save(dir string, url string, fetched []byte) error {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return err
+	}
+	sum := sha256.Sum256(fetched)
+	b, err := yaml.Marshal(Source{URL: url, FetchedAt: time.Now().UTC(), SHA256: hex.EncodeToString(sum[:])})
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, "base.yaml"), fetched, 0o600); err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, "source.yaml"), b, 0o600)
+}
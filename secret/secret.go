@@ -0,0 +1,110 @@
+// Package secret resolves the values of variables from providers: environment variables, files, commands and a local
+// encrypted store.
+package secret
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"github.com/dewey/tbm/config"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CommandTimeout is how long a command providing a value may run
+const CommandTimeout = time.Minute
+
+// Resolver resolves variables from their providers. Outputs of commands are cached for the duration the variable
+// asks for.
+type Resolver struct {
+	store *Store
+	mu    sync.Mutex
+	cache map[string]cached
+	now   func() time.Time
+}
+
+// cached is the output of a command and when it expires
+type cached struct {
+	value   string
+	expires time.Time
+}
+
+// NewResolver returns a resolver that reads values of the store provider from the given store
+func NewResolver(store *Store) *Resolver {
+	return &Resolver{store: store, cache: make(map[string]cached), now: time.Now}
+}
+
+// Resolve returns the value of a variable from its provider
+func (r *Resolver) Resolve(variable config.Variable) (string, error) {
+	switch variable.From {
+	case config.ProviderEnv:
+		value, ok := os.LookupEnv(variable.Name)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s isn't set", variable.Name)
+		}
+		return value, nil
+	case config.ProviderFile:
+		path, err := expandHome(variable.Path)
+		if err != nil {
+			return "", err
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(b), "\r\n"), nil
+	case config.ProviderCommand:
+		return r.command(variable.Run, variable.Cache)
+	case config.ProviderStore:
+		if r.store == nil {
+			return "", errors.New("no secret store available")
+		}
+		return r.store.Get(variable.Name)
+	}
+	return variable.Value, nil
+}
+
+// command runs a command and returns its output, it's reused until the cache duration passed
+func (r *Resolver) command(command string, cache time.Duration) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if c, ok := r.cache[command]; ok && r.now().Before(c.expires) {
+		return c.value, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), CommandTimeout)
+	defer cancel()
+	//nolint:gosec
+	cmd := exec.CommandContext(ctx, "/bin/sh", "-c", command)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if message := strings.TrimSpace(stderr.String()); message != "" {
+			return "", fmt.Errorf("%w: %s", err, message)
+		}
+		return "", err
+	}
+	value := strings.TrimRight(stdout.String(), "\r\n")
+	if cache > 0 {
+		r.cache[command] = cached{value: value, expires: r.now().Add(cache)}
+	}
+	return value, nil
+}
+
+// expandHome replaces a leading ~ with the home directory of the user
+func expandHome(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+	hd, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(hd, path[1:]), nil
+}
@@ -0,0 +1,114 @@
+package secret
+
+import (
+	"bytes"
+	"errors"
+	"github.com/dewey/tbm/config"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestResolver_Resolve(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "password")
+	if err := os.WriteFile(path, []byte("from-file\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TBM_TEST_PASSWORD", "from-env")
+	store := NewStore(filepath.Join(dir, "secrets.enc"), filepath.Join(dir, "secrets.key"))
+	if err := store.Set("db", "from-store"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		variable config.Variable
+		want     string
+		wantErr  bool
+	}{
+		{name: "value", variable: config.Variable{Value: "plain"}, want: "plain"},
+		{name: "env", variable: config.Variable{From: config.ProviderEnv, Name: "TBM_TEST_PASSWORD"}, want: "from-env"},
+		{name: "env missing", variable: config.Variable{From: config.ProviderEnv, Name: "TBM_TEST_MISSING"}, wantErr: true},
+		{name: "file", variable: config.Variable{From: config.ProviderFile, Path: path}, want: "from-file"},
+		{name: "command", variable: config.Variable{From: config.ProviderCommand, Run: "echo from-command"}, want: "from-command"},
+		{name: "command failing", variable: config.Variable{From: config.ProviderCommand, Run: "exit 1"}, wantErr: true},
+		{name: "store", variable: config.Variable{From: config.ProviderStore, Name: "db"}, want: "from-store"},
+		{name: "store missing", variable: config.Variable{From: config.ProviderStore, Name: "missing"}, wantErr: true},
+	}
+	r := NewResolver(store)
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := r.Resolve(tt.variable)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Resolve() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("Resolve() got = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestResolver_CommandCache(t *testing.T) {
+	counter := filepath.Join(t.TempDir(), "counter")
+	run := "echo x >> " + counter + " && wc -l < " + counter + " | tr -d ' '"
+	now := time.Date(2023, time.January, 10, 10, 0, 0, 0, time.UTC)
+	r := NewResolver(nil)
+	r.now = func() time.Time { return now }
+	variable := config.Variable{From: config.ProviderCommand, Run: run, Cache: 30 * time.Minute}
+
+	var got []string
+	for _, step := range []time.Duration{0, 10 * time.Minute, 30 * time.Minute} {
+		now = now.Add(step)
+		value, err := r.Resolve(variable)
+		if err != nil {
+			t.Fatalf("Resolve() error = %v", err)
+		}
+		got = append(got, value)
+	}
+	if want := []string{"1", "1", "2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Resolve() got = %v, want %v", got, want)
+	}
+}
+
+func TestStore(t *testing.T) {
+	dir := t.TempDir()
+	store := NewStore(filepath.Join(dir, "secrets.enc"), filepath.Join(dir, "secrets.key"))
+	if names, err := store.Names(); err != nil || len(names) != 0 {
+		t.Fatalf("Names() of a new store = %v, %v", names, err)
+	}
+	for name, value := range map[string]string{"db": "hunter2", "api": "abc"} {
+		if err := store.Set(name, value); err != nil {
+			t.Fatalf("Set() error = %v", err)
+		}
+	}
+	if err := store.Delete("api"); err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	if _, err := store.Get("api"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("Get() of deleted secret error = %v, want ErrNotFound", err)
+	}
+	if value, err := store.Get("db"); err != nil || value != "hunter2" {
+		t.Errorf("Get() = %v, %v, want hunter2", value, err)
+	}
+
+	b, err := os.ReadFile(filepath.Join(dir, "secrets.enc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(b) == 0 || bytes.Contains(b, []byte("hunter2")) {
+		t.Errorf("store file isn't encrypted")
+	}
+
+	// A store with another key can't be read
+	other := NewStore(filepath.Join(dir, "secrets.enc"), filepath.Join(t.TempDir(), "secrets.key"))
+	if err := os.WriteFile(other.keyPath, []byte("00000000000000000000000000000000000000000000000000000000000000ff\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := other.Get("db"); err == nil {
+		t.Errorf("Get() with the wrong key succeeded")
+	}
+}
@@ -0,0 +1,185 @@
+package secret
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// ErrNotFound is returned if the store has no value with the given name
+var ErrNotFound = errors.New("secret not found")
+
+// Store keeps secrets in a file encrypted with AES-GCM. The key is kept in a separate file that only the user can
+// read, it's created when the first secret is stored.
+type Store struct {
+	path    string
+	keyPath string
+}
+
+// NewStore returns a store for the encrypted file at path and the key at keyPath
+func NewStore(path string, keyPath string) *Store {
+	return &Store{path: path, keyPath: keyPath}
+}
+
+// DefaultStore returns the store in the user's config directory, like ~/.config/tbm/secrets.enc on Linux
+func DefaultStore() (*Store, error) {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return nil, err
+	}
+	dir = filepath.Join(dir, "tbm")
+	return NewStore(filepath.Join(dir, "secrets.enc"), filepath.Join(dir, "secrets.key")), nil
+}
+
+// Get returns the value of a secret
+func (s *Store) Get(name string) (string, error) {
+	secrets, err := s.load()
+	if err != nil {
+		return "", err
+	}
+	value, ok := secrets[name]
+	if !ok {
+		return "", fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	return value, nil
+}
+
+// Set stores the value of a secret, replacing an existing one
+func (s *Store) Set(name string, value string) error {
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	secrets[name] = value
+	return s.save(secrets)
+}
+
+// Delete removes a secret from the store
+func (s *Store) Delete(name string) error {
+	secrets, err := s.load()
+	if err != nil {
+		return err
+	}
+	if _, ok := secrets[name]; !ok {
+		return fmt.Errorf("%w: %s", ErrNotFound, name)
+	}
+	delete(secrets, name)
+	return s.save(secrets)
+}
+
+// Names returns the sorted names of all stored secrets
+func (s *Store) Names() ([]string, error) {
+	secrets, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for name := range secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// load decrypts all secrets, a store that doesn't exist yet is empty
+func (s *Store) load() (map[string]string, error) {
+	secrets := make(map[string]string)
+	b, err := os.ReadFile(s.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return secrets, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	key, err := s.key(false)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) < aead.NonceSize() {
+		return nil, fmt.Errorf("secret store %s is corrupted", s.path)
+	}
+	plaintext, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], nil)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't decrypt secret store %s, the key in %s doesn't match", s.path, s.keyPath)
+	}
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, err
+	}
+	return secrets, nil
+}
+
+// save encrypts all secrets with a new nonce and replaces the store
+func (s *Store) save(secrets map[string]string) error {
+	key, err := s.key(true)
+	if err != nil {
+		return err
+	}
+	aead, err := newAEAD(key)
+	if err != nil {
+		return err
+	}
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0o700); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, aead.Seal(nonce, nonce, plaintext, nil), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// key reads the key of the store, a new one is created if it doesn't exist and create is set
+func (s *Store) key(create bool) ([]byte, error) {
+	b, err := os.ReadFile(s.keyPath)
+	if errors.Is(err, os.ErrNotExist) && create {
+		key := make([]byte, 32)
+		if _, err := io.ReadFull(rand.Reader, key); err != nil {
+			return nil, err
+		}
+		if err := os.MkdirAll(filepath.Dir(s.keyPath), 0o700); err != nil {
+			return nil, err
+		}
+		if err := os.WriteFile(s.keyPath, []byte(hex.EncodeToString(key)+"\n"), 0o600); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("couldn't read the key of the secret store: %w", err)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil || len(key) != 32 {
+		return nil, fmt.Errorf("the key in %s isn't a hex encoded 256 bit key", s.keyPath)
+	}
+	return key, nil
+}
+
+// newAEAD returns AES-GCM for the key
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}